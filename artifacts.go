@@ -0,0 +1,223 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	artifactsFoldName = "artifacts"
+)
+
+// artifact is a file collected from a job's workspace, named by its slash
+// separated path relative to the workspace.
+type artifact struct {
+	path string
+	name string
+	size int64
+}
+
+// uploadArtifacts collects the workspace files matching the given patterns and
+// uploads each of them to the job's artifacts URL, which is expanded with the
+// artifact's name as {path}.  It returns the artifacts that were uploaded, for
+// the final state update.  Failures are reported in the job log, but do not
+// fail the job.
+func (er *execRunner) uploadArtifacts(ctx context.Context, log logrus.FieldLogger, job Job, ws *workspace, patterns []string, out io.Writer) []map[string]interface{} {
+	uploaded := []map[string]interface{}{}
+
+	foldStart(out, artifactsFoldName)
+	defer foldEnd(out, artifactsFoldName)
+
+	if job.ArtifactsURL() == "" {
+		log.Warn("job has no artifacts url")
+		fmt.Fprintln(out, "Not uploading artifacts: the job has no artifacts URL")
+		return uploaded
+	}
+
+	artifacts, err := collectArtifacts(ws, patterns)
+	if err != nil {
+		log.WithError(err).Warn("failed to collect artifacts")
+		fmt.Fprintf(out, "Failed to collect artifacts: %v\n", err)
+		return uploaded
+	}
+
+	fmt.Fprintf(out, "Uploading %d artifacts\n", len(artifacts))
+	for _, a := range artifacts {
+		u, err := expandJobURL(job.ArtifactsURL(), job, map[string]interface{}{"path": a.name})
+		if err == nil {
+			err = uploadArtifact(ctx, job, a, u)
+		}
+
+		if err != nil {
+			log.WithError(err).WithField("artifact", a.name).Warn("failed to upload artifact")
+			fmt.Fprintf(out, "Failed to upload %s: %v\n", a.name, err)
+			continue
+		}
+
+		fmt.Fprintf(out, "Uploaded %s (%d bytes)\n", a.name, a.size)
+		uploaded = append(uploaded, map[string]interface{}{
+			"path": a.name,
+			"size": a.size,
+		})
+	}
+
+	return uploaded
+}
+
+func uploadArtifact(ctx context.Context, job Job, a *artifact, u *url.URL) error {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	switch u.Scheme {
+	case "file":
+		dest, err := filepath.Abs(u.Host + u.Path)
+		if err != nil {
+			return errors.Wrap(err, "failed to find absolute dest path")
+		}
+
+		err = os.MkdirAll(filepath.Dir(dest), os.FileMode(0755))
+		if err != nil {
+			return err
+		}
+
+		return copyToFile(dest, f)
+	case "http", "https":
+		req, err := http.NewRequest("PUT", u.String(), f)
+		if err != nil {
+			return errors.Wrap(err, "couldn't create request")
+		}
+		req = req.WithContext(ctx)
+		req.ContentLength = a.size
+
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", job.JWT()))
+		req.Header.Set("Content-Type", "application/octet-stream")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return errors.Wrap(err, "error making artifact upload request")
+		}
+
+		defer resp.Body.Close()
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return errors.Errorf("expected 2xx, but got %d", resp.StatusCode)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unknown scheme %v", u.Scheme)
+	}
+}
+
+// collectArtifacts returns the regular files in the workspace matching any of
+// the patterns, which are resolved like other job paths and may use "**" to
+// match any number of directories.  Directories matching a pattern are
+// collected whole, and symlinks are never followed.
+func collectArtifacts(ws *workspace, patterns []string) ([]*artifact, error) {
+	artifacts := []*artifact{}
+	seen := map[string]bool{}
+
+	for _, pattern := range patterns {
+		resolved, err := ws.resolve(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		err = filepath.Walk(globRoot(resolved), func(path string, info os.FileInfo, err error) error {
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			if err != nil {
+				return err
+			}
+
+			if !info.Mode().IsRegular() || seen[path] || !matchGlob(resolved, path) {
+				return nil
+			}
+
+			name, err := filepath.Rel(ws.dir, path)
+			if err != nil {
+				return err
+			}
+
+			seen[path] = true
+			artifacts = append(artifacts, &artifact{
+				path: path,
+				name: filepath.ToSlash(name),
+				size: info.Size(),
+			})
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return artifacts, nil
+}
+
+// globRoot returns the longest leading part of the pattern without any glob
+// characters.
+func globRoot(pattern string) string {
+	root := string(filepath.Separator)
+	for _, part := range strings.Split(pattern, string(filepath.Separator)) {
+		if strings.ContainsAny(part, "*?[\\") {
+			break
+		}
+		root = filepath.Join(root, part)
+	}
+
+	return root
+}
+
+// matchGlob reports whether path, or one of its parent directories, matches
+// pattern, matching path elements with filepath.Match and "**" with any number
+// of path elements.
+func matchGlob(pattern, path string) bool {
+	return matchGlobParts(
+		strings.Split(pattern, string(filepath.Separator)),
+		strings.Split(path, string(filepath.Separator)))
+}
+
+func matchGlobParts(pattern, path []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(path); i++ {
+				if matchGlobParts(pattern[1:], path[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(path) == 0 {
+			return false
+		}
+
+		ok, err := filepath.Match(pattern[0], path[0])
+		if err != nil || !ok {
+			return false
+		}
+
+		pattern, path = pattern[1:], path[1:]
+	}
+
+	return true
+}
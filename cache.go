@@ -0,0 +1,514 @@
+package job
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	cacheDirName     = "cache"
+	cacheArchiveName = "cache.tgz"
+	cacheFoldName    = "cache.1"
+)
+
+var (
+	cacheMissErr = fmt.Errorf("cache archive not found")
+)
+
+// Cache stores build cache archives by key.
+type Cache interface {
+	// Fetch downloads the archive stored under key to dest, returning
+	// cacheMissErr if there is none.
+	Fetch(ctx context.Context, key, dest string) error
+
+	// Push uploads the archive at src under key.
+	Push(ctx context.Context, key, src string) error
+}
+
+// NewCache builds the Cache described by the given settings.
+func NewCache(settings *CacheSettings) (Cache, error) {
+	switch settings.Type {
+	case "s3":
+		if settings.S3 == nil {
+			return nil, fmt.Errorf("missing s3 cache settings")
+		}
+		return newS3Cache(settings.S3)
+	case "file":
+		return newFileCache(settings.URL)
+	default:
+		return nil, fmt.Errorf("unknown cache type %q", settings.Type)
+	}
+}
+
+// jobCache restores a job's cache directories from an archive before its
+// script runs and stores them again afterwards.  Cache directories come from
+// the job config's cache.directories and always include TRAVIS_CACHE_DIR,
+// and must live inside the job workspace.  Archives left in the warmed cache
+// by a warmer job are used instead of fetching them.
+type jobCache struct {
+	cache    Cache
+	warmed   *fileCache
+	settings *CacheSettings
+	ws       *workspace
+	keys     []string
+	dirs     []string
+}
+
+// newJobCache returns nil if the job has no cache settings or does not use
+// caching.  A non-empty overrideURL replaces the job's cache settings with a
+// file cache at that URL.
+func newJobCache(job Job, ws *workspace, overrideURL string) (*jobCache, error) {
+	md := job.Metadata()
+	if !cacheEnabled(md.Config) {
+		return nil, nil
+	}
+
+	settings := md.CacheSettings
+	if overrideURL != "" {
+		settings = &CacheSettings{Type: "file", URL: overrideURL}
+		if md.CacheSettings != nil {
+			settings.FetchTimeout = md.CacheSettings.FetchTimeout
+			settings.PushTimeout = md.CacheSettings.PushTimeout
+		}
+	}
+
+	if settings == nil {
+		return nil, nil
+	}
+
+	cache, err := NewCache(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	jc := &jobCache{
+		cache:    cache,
+		warmed:   &fileCache{dir: ws.warmCacheDir()},
+		settings: settings,
+		ws:       ws,
+		keys:     []string{},
+		dirs:     []string{filepath.Join(ws.dir, cacheDirName)},
+	}
+
+	for _, branch := range []string{md.Job.Branch, md.Repository.DefaultBranch} {
+		if branch == "" {
+			continue
+		}
+
+		key := fmt.Sprintf("%d/%s/%s", md.Repository.ID, url.PathEscape(branch), cacheArchiveName)
+		if len(jc.keys) == 0 || jc.keys[0] != key {
+			jc.keys = append(jc.keys, key)
+		}
+	}
+
+	if len(jc.keys) == 0 {
+		return nil, fmt.Errorf("cannot build cache key without a branch")
+	}
+
+	for _, dir := range cacheConfigDirectories(md.Config) {
+		resolved, err := ws.resolve(dir)
+		if err != nil {
+			return nil, err
+		}
+		jc.dirs = append(jc.dirs, resolved)
+	}
+
+	return jc, nil
+}
+
+func (jc *jobCache) environ() []string {
+	return []string{
+		fmt.Sprintf("TRAVIS_CACHE_DIR=%s", jc.dirs[0]),
+		fmt.Sprintf("TRAVIS_CACHE_DIRECTORIES=%s", strings.Join(jc.dirs, string(os.PathListSeparator))),
+	}
+}
+
+// fetch creates TRAVIS_CACHE_DIR and restores the cache from the first key
+// that has an archive.  Failures are reported in the job log, but do not fail
+// the job.
+func (jc *jobCache) fetch(ctx context.Context, log logrus.FieldLogger, out io.Writer) {
+	foldStart(out, cacheFoldName)
+	defer foldEnd(out, cacheFoldName)
+
+	err := os.MkdirAll(jc.dirs[0], os.FileMode(0700))
+	if err != nil {
+		log.WithError(err).Warn("failed to create cache dir")
+		fmt.Fprintf(out, "Failed to create cache dir: %v\n", err)
+		return
+	}
+
+	if jc.settings.FetchTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, jc.settings.FetchTimeout)
+		defer cancel()
+	}
+
+	archive := filepath.Join(jc.ws.dir, cacheArchiveName)
+	defer os.Remove(archive)
+
+	for _, key := range jc.keys {
+		fmt.Fprintf(out, "Fetching cache %s\n", key)
+		err = jc.fetchArchive(ctx, log, key, archive)
+		if err == cacheMissErr {
+			fmt.Fprintf(out, "No cache found for %s\n", key)
+			continue
+		}
+
+		if err != nil {
+			log.WithError(err).WithField("key", key).Warn("failed to fetch cache")
+			fmt.Fprintf(out, "Failed to fetch cache: %v\n", err)
+			return
+		}
+
+		err = extractCacheArchive(archive, jc.ws.dir)
+		if err != nil {
+			log.WithError(err).WithField("key", key).Warn("failed to extract cache")
+			fmt.Fprintf(out, "Failed to extract cache: %v\n", err)
+			return
+		}
+
+		fmt.Fprintf(out, "Restored cache from %s\n", key)
+		return
+	}
+}
+
+// fetchArchive fetches the archive stored under key to dest, preferring one
+// left in the warmed cache by a warmer job.  The warmed cache is skipped
+// unless it is a private directory of this processor's user.
+func (jc *jobCache) fetchArchive(ctx context.Context, log logrus.FieldLogger, key, dest string) error {
+	err := checkPrivateDir(jc.warmed.dir)
+	if err == nil {
+		err = jc.warmed.take(key, dest)
+	}
+
+	if err == nil {
+		log.WithField("key", key).Debug("using warmed cache")
+		return nil
+	}
+
+	if err != cacheMissErr && !os.IsNotExist(err) {
+		log.WithError(err).WithField("key", key).Warn("failed to use warmed cache")
+	}
+
+	return jc.cache.Fetch(ctx, key, dest)
+}
+
+// warm fetches the archive of the first key that has one into the warmed
+// cache, where fetch picks it up for the next job of the same repository and
+// branch on this box.  Failures are reported in the job log.
+func (jc *jobCache) warm(ctx context.Context, log logrus.FieldLogger, out io.Writer) {
+	foldStart(out, cacheFoldName)
+	defer foldEnd(out, cacheFoldName)
+
+	if jc.settings.FetchTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, jc.settings.FetchTimeout)
+		defer cancel()
+	}
+
+	err := privateDir(jc.warmed.dir)
+	if err != nil {
+		log.WithError(err).Warn("refusing to use warmed cache dir")
+		fmt.Fprintf(out, "Failed to warm cache: %v\n", err)
+		return
+	}
+
+	archive := filepath.Join(jc.ws.dir, cacheArchiveName)
+	defer os.Remove(archive)
+
+	for _, key := range jc.keys {
+		fmt.Fprintf(out, "Fetching cache %s\n", key)
+		err = jc.cache.Fetch(ctx, key, archive)
+		if err == cacheMissErr {
+			fmt.Fprintf(out, "No cache found for %s\n", key)
+			continue
+		}
+
+		if err == nil {
+			err = jc.warmed.Push(ctx, key, archive)
+		}
+
+		if err != nil {
+			log.WithError(err).WithField("key", key).Warn("failed to warm cache")
+			fmt.Fprintf(out, "Failed to warm cache: %v\n", err)
+			return
+		}
+
+		fmt.Fprintf(out, "Kept cache %s for the next job\n", key)
+		return
+	}
+}
+
+// push stores the cache directories under the job's own key.  Failures are
+// reported in the job log, but do not fail the job.
+func (jc *jobCache) push(ctx context.Context, log logrus.FieldLogger, out io.Writer) {
+	foldStart(out, cacheFoldName)
+	defer foldEnd(out, cacheFoldName)
+
+	if jc.settings.PushTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, jc.settings.PushTimeout)
+		defer cancel()
+	}
+
+	archive := filepath.Join(jc.ws.dir, cacheArchiveName)
+	defer os.Remove(archive)
+
+	key := jc.keys[0]
+	fmt.Fprintf(out, "Storing cache %s\n", key)
+
+	err := createCacheArchive(archive, jc.ws.dir, jc.dirs)
+	if err != nil {
+		log.WithError(err).WithField("key", key).Warn("failed to archive cache")
+		fmt.Fprintf(out, "Failed to archive cache: %v\n", err)
+		return
+	}
+
+	err = jc.cache.Push(ctx, key, archive)
+	if err != nil {
+		log.WithError(err).WithField("key", key).Warn("failed to push cache")
+		fmt.Fprintf(out, "Failed to store cache: %v\n", err)
+		return
+	}
+
+	fmt.Fprintf(out, "Stored cache %s\n", key)
+}
+
+// cacheEnabled reports whether the job config asks for caching at all, which
+// is the case for any cache setting other than false.
+func cacheEnabled(config map[string]interface{}) bool {
+	switch v := config["cache"].(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	default:
+		return true
+	}
+}
+
+func cacheConfigDirectories(config map[string]interface{}) []string {
+	cacheConfig, ok := config["cache"].(map[string]interface{})
+	if !ok {
+		return []string{}
+	}
+
+	dirs := []string{}
+	switch v := cacheConfig["directories"].(type) {
+	case string:
+		dirs = append(dirs, v)
+	case []interface{}:
+		for _, dir := range v {
+			if s, ok := dir.(string); ok && s != "" {
+				dirs = append(dirs, s)
+			}
+		}
+	}
+
+	return dirs
+}
+
+// createCacheArchive writes a gzipped tarball of dirs, which must be inside
+// root, with paths relative to root.
+func createCacheArchive(dest, root string, dirs []string) error {
+	f, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(0600))
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	gzw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gzw)
+
+	for _, dir := range dirs {
+		err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			if err != nil {
+				return err
+			}
+
+			return addCacheArchiveEntry(tw, root, path, info)
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+
+	err = gzw.Close()
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+func addCacheArchiveEntry(tw *tar.Writer, root, path string, info os.FileInfo) error {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return err
+	}
+
+	link := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, err = os.Readlink(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+
+	hdr.Name = filepath.ToSlash(rel)
+	err = tw.WriteHeader(hdr)
+	if err != nil {
+		return err
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// extractCacheArchive unpacks a gzipped tarball into root, refusing entries
+// that would end up outside of it.
+func extractCacheArchive(src, root string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+
+	defer gzr.Close()
+
+	tr := tar.NewReader(gzr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		dest := filepath.Join(root, filepath.FromSlash(hdr.Name))
+		if !pathWithin(root, dest) {
+			return fmt.Errorf("cache archive entry %q is outside of the workspace", hdr.Name)
+		}
+
+		checked := dest
+		if hdr.Typeflag == tar.TypeSymlink {
+			checked = filepath.Dir(dest)
+		}
+
+		if !resolvedWithin(root, checked) {
+			return fmt.Errorf("cache archive entry %q resolves outside of the workspace", hdr.Name)
+		}
+
+		mode := os.FileMode(hdr.Mode).Perm()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(dest, mode|os.FileMode(0700))
+		case tar.TypeReg:
+			err = extractCacheArchiveFile(tr, dest, mode)
+		case tar.TypeSymlink:
+			_ = os.Remove(dest)
+			err = os.Symlink(hdr.Linkname, dest)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func extractCacheArchiveFile(r io.Reader, dest string, mode os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(dest), os.FileMode(0700))
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+func pathWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// resolvedWithin reports whether path, or its nearest existing ancestor, is
+// inside root once symlinks are resolved.
+func resolvedWithin(root, path string) bool {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return false
+	}
+
+	existing := path
+	for {
+		_, err := os.Lstat(existing)
+		if err == nil {
+			break
+		}
+
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return false
+		}
+		existing = parent
+	}
+
+	realPath, err := filepath.EvalSymlinks(existing)
+	return err == nil && pathWithin(realRoot, realPath)
+}
@@ -0,0 +1,101 @@
+package job
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// fileCache stores cache archives in a local directory given as a file://
+// URL, which is mostly useful for local runs.
+type fileCache struct {
+	dir string
+}
+
+func newFileCache(cacheURL string) (Cache, error) {
+	u, err := url.Parse(cacheURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't parse cache URL")
+	}
+
+	if u.Scheme != "file" {
+		return nil, errors.Errorf("unknown cache scheme %v", u.Scheme)
+	}
+
+	dir, err := filepath.Abs(u.Host + u.Path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find absolute cache path")
+	}
+
+	return &fileCache{dir: dir}, nil
+}
+
+func (fc *fileCache) Fetch(ctx context.Context, key, dest string) error {
+	src, err := os.Open(filepath.Join(fc.dir, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return cacheMissErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer src.Close()
+	return copyToFile(dest, src)
+}
+
+func (fc *fileCache) Push(ctx context.Context, key, src string) error {
+	dest := filepath.Join(fc.dir, filepath.FromSlash(key))
+	err := os.MkdirAll(filepath.Dir(dest), os.FileMode(0755))
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	// write next to the destination and rename so that concurrent fetches
+	// never see a partial archive
+	tmp := dest + ".tmp"
+	err = copyToFile(tmp, f)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, dest)
+}
+
+// take moves the archive stored under key to dest, so that it is only ever
+// used once, returning cacheMissErr if there is none.
+func (fc *fileCache) take(key, dest string) error {
+	err := os.Rename(filepath.Join(fc.dir, filepath.FromSlash(key)), dest)
+	if os.IsNotExist(err) {
+		return cacheMissErr
+	}
+
+	return err
+}
+
+func copyToFile(dest string, r io.Reader) error {
+	f, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(0600))
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
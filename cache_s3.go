@@ -0,0 +1,220 @@
+package job
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultS3Region    = "us-east-1"
+	s3SigningAlgorithm = "AWS4-HMAC-SHA256"
+	s3AmzDateFormat    = "20060102T150405Z"
+)
+
+// s3Cache stores cache archives in an S3-compatible bucket, addressed in path
+// style and signed with AWS signature version 4.  The hostname may carry a
+// scheme, e.g. "http://127.0.0.1:9000" for a local stand-in, and defaults to
+// https otherwise.
+type s3Cache struct {
+	endpoint *url.URL
+	settings *S3CacheSettings
+	region   string
+	client   *http.Client
+}
+
+func newS3Cache(settings *S3CacheSettings) (Cache, error) {
+	if settings.SignatureVersion != "" && settings.SignatureVersion != "4" {
+		return nil, fmt.Errorf("unsupported aws signature version %q", settings.SignatureVersion)
+	}
+
+	if settings.Bucket == "" {
+		return nil, fmt.Errorf("missing s3 cache bucket")
+	}
+
+	hostname := settings.Hostname
+	if hostname == "" {
+		hostname = "s3.amazonaws.com"
+	}
+
+	if !strings.Contains(hostname, "://") {
+		hostname = "https://" + hostname
+	}
+
+	endpoint, err := url.Parse(hostname)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't parse s3 cache hostname")
+	}
+
+	region := settings.Region
+	if region == "" {
+		region = defaultS3Region
+	}
+
+	return &s3Cache{
+		endpoint: endpoint,
+		settings: settings,
+		region:   region,
+		client:   &http.Client{},
+	}, nil
+}
+
+func (sc *s3Cache) Fetch(ctx context.Context, key, dest string) error {
+	req, err := sc.newRequest(ctx, "GET", key, nil, sha256Hex(nil), 0)
+	if err != nil {
+		return err
+	}
+
+	resp, err := sc.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "error making s3 cache fetch request")
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return cacheMissErr
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("expected %d, but got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	return copyToFile(dest, resp.Body)
+}
+
+func (sc *s3Cache) Push(ctx context.Context, key, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return errors.Wrap(err, "failed to hash cache archive")
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	req, err := sc.newRequest(ctx, "PUT", key, f, hex.EncodeToString(hash.Sum(nil)), size)
+	if err != nil {
+		return err
+	}
+
+	resp, err := sc.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "error making s3 cache push request")
+	}
+
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("expected %d, but got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (sc *s3Cache) newRequest(ctx context.Context, method, key string, body io.Reader, payloadHash string, size int64) (*http.Request, error) {
+	u := *sc.endpoint
+	u.Path = "/" + sc.settings.Bucket + "/" + key
+	u.RawPath = s3EscapePath(u.Path)
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't create s3 cache request")
+	}
+
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.ContentLength = size
+	}
+
+	sc.sign(req, payloadHash, time.Now().UTC())
+	return req, nil
+}
+
+// sign adds an AWS signature version 4 Authorization header to the request,
+// covering the host, payload hash and date headers.
+func (sc *s3Cache) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format(s3AmzDateFormat)
+	scope := strings.Join([]string{now.Format("20060102"), sc.region, "s3", "aws4_request"}, "/")
+
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	stringToSign := strings.Join([]string{
+		s3SigningAlgorithm,
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+sc.settings.SecretAccessKey), now.Format("20060102"))
+	for _, part := range []string{sc.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3SigningAlgorithm, sc.settings.AccessKeyID, scope, signedHeaders,
+		hex.EncodeToString(hmacSHA256(key, stringToSign))))
+}
+
+// s3EscapePath escapes every byte of the path other than unreserved
+// characters and slashes, as signature version 4 requires.
+func s3EscapePath(path string) string {
+	escaped := &strings.Builder{}
+	for _, b := range []byte(path) {
+		switch {
+		case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9',
+			b == '-', b == '_', b == '.', b == '~', b == '/':
+			escaped.WriteByte(b)
+		default:
+			fmt.Fprintf(escaped, "%%%02X", b)
+		}
+	}
+
+	return escaped.String()
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,296 @@
+package job
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var testS3AuthorizationRegexp = regexp.MustCompile(
+	`^AWS4-HMAC-SHA256 Credential=([^/]+)/(\d{8})/([^/]+)/s3/aws4_request, SignedHeaders=([^,]+), Signature=([0-9a-f]{64})$`)
+
+// testS3Server is a stand-in for an S3 bucket which verifies signature
+// version 4 signatures independently of s3Cache.
+type testS3Server struct {
+	t         *testing.T
+	accessKey string
+	secretKey string
+	region    string
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	paths   []string
+}
+
+func (ts *testS3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := ts.verify(r, body); err != nil {
+		ts.t.Logf("rejecting %s %s: %v", r.Method, r.URL.EscapedPath(), err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.paths = append(ts.paths, r.URL.EscapedPath())
+
+	switch r.Method {
+	case "PUT":
+		if r.ContentLength != int64(len(body)) {
+			http.Error(w, "bad content length", http.StatusBadRequest)
+			return
+		}
+		ts.objects[r.URL.Path] = body
+	case "GET":
+		object, ok := ts.objects[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(object)
+	default:
+		http.Error(w, "unexpected method", http.StatusMethodNotAllowed)
+	}
+}
+
+func (ts *testS3Server) verify(r *http.Request, body []byte) error {
+	m := testS3AuthorizationRegexp.FindStringSubmatch(r.Header.Get("Authorization"))
+	if m == nil {
+		return errors.Errorf("malformed authorization %q", r.Header.Get("Authorization"))
+	}
+
+	accessKey, date, region, signedHeaders, signature := m[1], m[2], m[3], m[4], m[5]
+	if accessKey != ts.accessKey {
+		return errors.Errorf("unknown access key %q", accessKey)
+	}
+	if region != ts.region {
+		return errors.Errorf("wrong region %q", region)
+	}
+	if signedHeaders != "host;x-amz-content-sha256;x-amz-date" {
+		return errors.Errorf("unexpected signed headers %q", signedHeaders)
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	if !strings.HasPrefix(amzDate, date) {
+		return errors.Errorf("date %q doesn't match scope %q", amzDate, date)
+	}
+
+	bodyHash := sha256.Sum256(body)
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash != hex.EncodeToString(bodyHash[:]) {
+		return errors.New("payload hash doesn't match body")
+	}
+
+	canonicalRequest := r.Method + "\n" +
+		r.URL.EscapedPath() + "\n" +
+		r.URL.RawQuery + "\n" +
+		"host:" + r.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n" +
+		"\n" +
+		signedHeaders + "\n" +
+		payloadHash
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+
+	stringToSign := "AWS4-HMAC-SHA256\n" +
+		amzDate + "\n" +
+		date + "/" + region + "/s3/aws4_request\n" +
+		hex.EncodeToString(canonicalHash[:])
+
+	mac := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		_, _ = h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+
+	key := mac(mac(mac(mac([]byte("AWS4"+ts.secretKey), date), region), "s3"), "aws4_request")
+	expected := hex.EncodeToString(mac(key, stringToSign))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return errors.New("signature mismatch")
+	}
+
+	return nil
+}
+
+func newTestS3Server(t *testing.T) (*testS3Server, *httptest.Server) {
+	ts := &testS3Server{
+		t:         t,
+		accessKey: "AKIDEXAMPLE",
+		secretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		region:    "eu-west-1",
+		objects:   map[string][]byte{},
+	}
+
+	srv := httptest.NewServer(ts)
+	t.Cleanup(srv.Close)
+	return ts, srv
+}
+
+func TestS3Cache(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		key     string
+		secret  string
+		region  string
+		pushErr string
+		path    string
+	}{
+		{
+			name: "plain key",
+			key:  "1/master/cache--rvm-default.tgz",
+			path: "/bucket/1/master/cache--rvm-default.tgz",
+		},
+		{
+			name: "key needing escaping",
+			key:  "1/feature%2Fx y/cache+linux.tgz",
+			path: "/bucket/1/feature%252Fx%20y/cache%2Blinux.tgz",
+		},
+		{
+			name:    "wrong secret",
+			key:     "1/master/cache.tgz",
+			secret:  "wrong",
+			pushErr: "expected 200, but got 403",
+		},
+		{
+			name:    "wrong region",
+			key:     "1/master/cache.tgz",
+			region:  "us-east-1",
+			pushErr: "expected 200, but got 403",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ts, srv := newTestS3Server(t)
+
+			secret := ts.secretKey
+			if tc.secret != "" {
+				secret = tc.secret
+			}
+
+			region := ts.region
+			if tc.region != "" {
+				region = tc.region
+			}
+
+			cache, err := newS3Cache(&S3CacheSettings{
+				Hostname:        srv.URL,
+				Bucket:          "bucket",
+				Region:          region,
+				AccessKeyID:     ts.accessKey,
+				SecretAccessKey: secret,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tmp := t.TempDir()
+			src := filepath.Join(tmp, "src.tgz")
+			if err := ioutil.WriteFile(src, []byte("archive contents"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := context.Background()
+			err = cache.Push(ctx, tc.key, src)
+			if tc.pushErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.pushErr) {
+					t.Fatalf("expected push error containing %q, got %v", tc.pushErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected push error: %v", err)
+			}
+
+			dest := filepath.Join(tmp, "dest.tgz")
+			if err := cache.Fetch(ctx, tc.key, dest); err != nil {
+				t.Fatalf("unexpected fetch error: %v", err)
+			}
+
+			fetched, err := ioutil.ReadFile(dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(fetched) != "archive contents" {
+				t.Fatalf("expected fetched archive to match pushed one, got %q", fetched)
+			}
+
+			for _, path := range ts.paths {
+				if path != tc.path {
+					t.Errorf("expected request path %q, got %q", tc.path, path)
+				}
+			}
+
+			err = cache.Fetch(ctx, tc.key+".missing", filepath.Join(tmp, "missing.tgz"))
+			if err != cacheMissErr {
+				t.Fatalf("expected a cache miss, got %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(tmp, "missing.tgz")); err == nil {
+				t.Fatalf("expected no archive for a cache miss")
+			}
+		})
+	}
+}
+
+func TestS3CacheSign(t *testing.T) {
+	cache, err := newS3Cache(&S3CacheSettings{
+		Hostname:        "s3.example.com",
+		Bucket:          "bucket",
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(secret string, now time.Time) string {
+		req, err := http.NewRequest("GET", "https://s3.example.com/bucket/key", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sc := *cache.(*s3Cache)
+		sc.settings = &S3CacheSettings{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: secret}
+		sc.sign(req, sha256Hex(nil), now)
+
+		if req.Header.Get("X-Amz-Date") != now.Format(s3AmzDateFormat) {
+			t.Fatalf("unexpected date header %q", req.Header.Get("X-Amz-Date"))
+		}
+
+		return req.Header.Get("Authorization")
+	}
+
+	now := time.Date(2013, 5, 24, 0, 0, 0, 0, time.UTC)
+	auth := sign("secret", now)
+
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20130524/us-east-1/s3/aws4_request, ") {
+		t.Fatalf("unexpected authorization %q", auth)
+	}
+	if auth != sign("secret", now) {
+		t.Fatalf("expected signing to be deterministic")
+	}
+	if auth == sign("other", now) {
+		t.Fatalf("expected signature to depend on the secret")
+	}
+	if auth == sign("secret", now.Add(time.Second)) {
+		t.Fatalf("expected signature to depend on the date")
+	}
+}
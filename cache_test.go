@@ -0,0 +1,270 @@
+package job
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+type testArchiveEntry struct {
+	name     string
+	typeflag byte
+	body     string
+	linkname string
+}
+
+func writeTestArchive(t *testing.T, path string, entries []testArchiveEntry) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	gzw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gzw)
+
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typeflag,
+			Linkname: e.linkname,
+			Mode:     0644,
+			Size:     int64(len(e.body)),
+		}
+		if e.typeflag == tar.TypeDir {
+			hdr.Mode = 0755
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractCacheArchive(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// existing are symlinks already in the workspace, e.g. made by the
+		// job, from name to target.  A target of "outside" is replaced by
+		// the directory next to the workspace.
+		existing map[string]string
+		entries  []testArchiveEntry
+		files    map[string]string
+		err      string
+	}{
+		{
+			name: "files and directories",
+			entries: []testArchiveEntry{
+				{name: "a/", typeflag: tar.TypeDir},
+				{name: "a/b", typeflag: tar.TypeReg, body: "hello"},
+				{name: "c/d", typeflag: tar.TypeReg, body: "nested"},
+			},
+			files: map[string]string{"a/b": "hello", "c/d": "nested"},
+		},
+		{
+			name:    "parent directory",
+			entries: []testArchiveEntry{{name: "../evil", typeflag: tar.TypeReg, body: "pwned"}},
+			err:     "outside of the workspace",
+		},
+		{
+			name:    "parent directory within a path",
+			entries: []testArchiveEntry{{name: "a/../../evil", typeflag: tar.TypeReg, body: "pwned"}},
+			err:     "outside of the workspace",
+		},
+		{
+			name:    "absolute path",
+			entries: []testArchiveEntry{{name: "/etc/evil", typeflag: tar.TypeReg, body: "contained"}},
+			files:   map[string]string{"etc/evil": "contained"},
+		},
+		{
+			name: "symlink pointing outside",
+			entries: []testArchiveEntry{
+				{name: "link", typeflag: tar.TypeSymlink, linkname: "/usr"},
+			},
+		},
+		{
+			name: "file through an archived symlink",
+			entries: []testArchiveEntry{
+				{name: "link", typeflag: tar.TypeSymlink, linkname: "outside"},
+				{name: "link/evil", typeflag: tar.TypeReg, body: "pwned"},
+			},
+			err: "resolves outside of the workspace",
+		},
+		{
+			name: "symlink through an archived symlink",
+			entries: []testArchiveEntry{
+				{name: "link", typeflag: tar.TypeSymlink, linkname: "outside"},
+				{name: "link/evil", typeflag: tar.TypeSymlink, linkname: "/etc/passwd"},
+			},
+			err: "resolves outside of the workspace",
+		},
+		{
+			name:     "file through an existing symlink",
+			existing: map[string]string{"link": "outside"},
+			entries: []testArchiveEntry{
+				{name: "link/evil", typeflag: tar.TypeReg, body: "pwned"},
+			},
+			err: "resolves outside of the workspace",
+		},
+		{
+			name:     "directory through an existing symlink",
+			existing: map[string]string{"link": "outside"},
+			entries: []testArchiveEntry{
+				{name: "link/evil/", typeflag: tar.TypeDir},
+			},
+			err: "resolves outside of the workspace",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tmp := t.TempDir()
+			root := filepath.Join(tmp, "root")
+			outside := filepath.Join(tmp, "outside")
+
+			for _, dir := range []string{root, outside} {
+				if err := os.Mkdir(dir, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			resolveTarget := func(target string) string {
+				if target == "outside" {
+					return outside
+				}
+				return target
+			}
+
+			for name, target := range tc.existing {
+				if err := os.Symlink(resolveTarget(target), filepath.Join(root, name)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			entries := append([]testArchiveEntry{}, tc.entries...)
+			for i := range entries {
+				entries[i].linkname = resolveTarget(entries[i].linkname)
+			}
+
+			archive := filepath.Join(tmp, "cache.tgz")
+			writeTestArchive(t, archive, entries)
+
+			err := extractCacheArchive(archive, root)
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected error containing %q, got %v", tc.err, err)
+			}
+
+			for name, expected := range tc.files {
+				actual, err := ioutil.ReadFile(filepath.Join(root, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(actual) != expected {
+					t.Errorf("expected %s to contain %q, got %q", name, expected, actual)
+				}
+			}
+
+			for _, dir := range []string{tmp, outside} {
+				if _, err := os.Lstat(filepath.Join(dir, "evil")); err == nil {
+					t.Errorf("archive wrote outside of the workspace to %s", filepath.Join(dir, "evil"))
+				}
+			}
+		})
+	}
+}
+
+func TestJobCacheFetchArchive(t *testing.T) {
+	key := "44461/master/cache.tgz"
+
+	for _, tc := range []struct {
+		name     string
+		warmed   func(t *testing.T, dir string)
+		expected string
+	}{
+		{
+			name:     "no warmed cache",
+			warmed:   func(t *testing.T, dir string) {},
+			expected: "remote",
+		},
+		{
+			name: "warmed cache",
+			warmed: func(t *testing.T, dir string) {
+				mustMkdir(t, dir, 0700)
+			},
+			expected: "warmed",
+		},
+		{
+			name: "warmed cache open to others",
+			warmed: func(t *testing.T, dir string) {
+				mustMkdir(t, dir, 0777)
+			},
+			expected: "remote",
+		},
+		{
+			name: "warmed cache pre-created by another user",
+			warmed: func(t *testing.T, dir string) {
+				mustMkdirAs(t, dir, testOtherUID)
+			},
+			expected: "remote",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tmp := t.TempDir()
+			remote := &fileCache{dir: filepath.Join(tmp, "remote")}
+			warmed := &fileCache{dir: filepath.Join(tmp, warmCacheDirName)}
+
+			push := func(fc *fileCache, content string) {
+				src := filepath.Join(tmp, "src.tgz")
+				if err := ioutil.WriteFile(src, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := fc.Push(context.Background(), key, src); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			push(remote, "remote")
+			tc.warmed(t, warmed.dir)
+			if _, err := os.Lstat(warmed.dir); err == nil {
+				push(warmed, "warmed")
+			}
+
+			log := logrus.New()
+			log.Out = ioutil.Discard
+
+			jc := &jobCache{cache: remote, warmed: warmed}
+			dest := filepath.Join(tmp, "dest.tgz")
+			if err := jc.fetchArchive(context.Background(), log, key, dest); err != nil {
+				t.Fatal(err)
+			}
+
+			actual, err := ioutil.ReadFile(dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(actual) != tc.expected {
+				t.Fatalf("expected the %s archive, got %q", tc.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	checkoutFoldName     = "git.checkout"
+	defaultCheckoutDepth = 50
+)
+
+// checkout clones the job's repository into the build dir and checks out the
+// job's commit, or the merge ref of its pull request.  The repository is
+// fetched from data.repository.source_url, which may also be a local path.
+func (er *execRunner) checkout(ctx context.Context, log logrus.FieldLogger, job Job, ws *workspace, out io.Writer) error {
+	foldStart(out, checkoutFoldName)
+	defer foldEnd(out, checkoutFoldName)
+
+	md := job.Metadata()
+	if md.Repository.SourceURL == "" {
+		return fmt.Errorf("job has no repository source url")
+	}
+
+	ref, rev := checkoutRef(md.Job)
+	if ref == "" && rev == "" {
+		return fmt.Errorf("job has no commit, ref or branch to check out")
+	}
+
+	env := append(append(er.baseEnviron(), ws.environ()...), "GIT_TERMINAL_PROMPT=0")
+	git := func(args ...string) error {
+		fmt.Fprintf(out, "$ git %s\n", strings.Join(args, " "))
+		return er.runCommand(ctx, log, exec.Command("git", args...), ws.buildDir, env, out)
+	}
+
+	fetch := func(refspec string) error {
+		args := []string{"fetch", "origin", refspec}
+		if er.cfg.CheckoutDepth > 0 {
+			args = []string{"fetch", fmt.Sprintf("--depth=%d", er.cfg.CheckoutDepth), "origin", refspec}
+		}
+		return git(args...)
+	}
+
+	log.WithFields(logrus.Fields{
+		"source_url": md.Repository.SourceURL,
+		"ref":        ref,
+		"commit":     rev,
+	}).Debug("checking out source")
+
+	for _, args := range [][]string{
+		{"init", "--quiet", "."},
+		{"remote", "add", "origin", md.Repository.SourceURL},
+	} {
+		err := git(args...)
+		if err != nil {
+			return errors.Wrapf(err, "git %s failed", args[0])
+		}
+	}
+
+	if ref != "" {
+		err := fetch(ref)
+		if err != nil {
+			return errors.Wrapf(err, "failed to fetch %s", ref)
+		}
+	}
+
+	if rev == "" {
+		rev = "FETCH_HEAD"
+	} else if ref == "" || !er.hasCommit(ctx, log, ws, env, rev) {
+		// The commit may be beyond the fetched ref's depth, or the branch
+		// may have moved on since, so fetch it by itself.
+		err := fetch(rev)
+		if err != nil {
+			return errors.Wrapf(err, "failed to fetch %s", rev)
+		}
+	}
+
+	err := git("checkout", "--quiet", "--force", rev)
+	if err != nil {
+		return errors.Wrapf(err, "failed to check out %s", rev)
+	}
+
+	return nil
+}
+
+func (er *execRunner) hasCommit(ctx context.Context, log logrus.FieldLogger, ws *workspace, env []string, rev string) bool {
+	cmd := exec.Command("git", "cat-file", "-e", rev+"^{commit}")
+	return er.runCommand(ctx, log, cmd, ws.buildDir, env, ioutil.Discard) == nil
+}
+
+// checkoutRef returns the ref to fetch and the revision to check out for the
+// job.  Pull requests are checked out at the head of their merge ref rather
+// than at the job's commit.
+func checkoutRef(jm JobMetadata) (string, string) {
+	switch {
+	case jm.IsPullRequest():
+		return fmt.Sprintf("+refs/pull/%d/merge:", jm.PullRequest), ""
+	case jm.Tag != "":
+		return "refs/tags/" + jm.Tag, jm.Commit
+	case jm.Ref != "":
+		return jm.Ref, jm.Commit
+	case jm.Branch != "":
+		return "refs/heads/" + jm.Branch, jm.Commit
+	default:
+		return "", jm.Commit
+	}
+}
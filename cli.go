@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/google/uuid"
@@ -26,17 +28,190 @@ func NewApp() *cli.App {
 				Usage:   "enable debug logging and ensure TRAVIS_DEBUG is set in all subshells",
 				EnvVars: envVars("DEBUG"),
 			},
+			&cli.DurationFlag{
+				Name:    "debug-hold",
+				Usage:   "time to keep a finished job around for debugging via a shell on a unix socket (0 to disable)",
+				EnvVars: envVars("DEBUG_HOLD"),
+			},
+			&cli.StringFlag{
+				Name:    "debug-shell",
+				Value:   defaultDebugShell,
+				Usage:   "shell served to debug sessions during a debug hold",
+				EnvVars: envVars("DEBUG_SHELL"),
+			},
 			&cli.StringFlag{
 				Name:    "health-url",
 				Usage:   "url for runtime health",
 				EnvVars: envVars("HEALTH_URL"),
 			},
+			&cli.DurationFlag{
+				Name:    "health-interval",
+				Value:   defaultHealthInterval,
+				Usage:   "interval between health reports to the health url",
+				EnvVars: envVars("HEALTH_INTERVAL"),
+			},
+			&cli.StringFlag{
+				Name:    "health-listen",
+				Usage:   "address to serve /healthz and /readyz on",
+				EnvVars: envVars("HEALTH_LISTEN"),
+			},
+			&cli.StringFlag{
+				Name:    "metrics-listen",
+				Usage:   "address to serve prometheus metrics on at /metrics",
+				EnvVars: envVars("METRICS_LISTEN"),
+			},
 			&cli.DurationFlag{
 				Name:    "max-lifetime",
 				Value:   5 * 60 * time.Minute,
 				Usage:   "max amount of time to wait before imploding",
 				EnvVars: envVars("MAX_LIFETIME"),
 			},
+			&cli.StringSliceFlag{
+				Name:    "script-key",
+				Usage:   "key used to verify job script signatures, as <ed25519|hmac-sha256>:<key-id>:<base64-key>",
+				EnvVars: envVars("SCRIPT_KEYS"),
+			},
+			&cli.BoolFlag{
+				Name:    "strict-script-signatures",
+				Value:   false,
+				Usage:   "reject job scripts that are not signed",
+				EnvVars: envVars("STRICT_SCRIPT_SIGNATURES"),
+			},
+			&cli.StringSliceFlag{
+				Name:    "interpreter",
+				Usage:   "additional job script interpreter, as <name>[:<ext>...]=<command> [<arg>...]",
+				EnvVars: envVars("INTERPRETERS"),
+			},
+			&cli.StringFlag{
+				Name:    "workspace-root",
+				Usage:   "directory in which per-job workspaces are created (default: system temp dir)",
+				EnvVars: envVars("WORKSPACE_ROOT"),
+			},
+			&cli.BoolFlag{
+				Name:    "keep-workspace",
+				Value:   false,
+				Usage:   "keep job workspaces after the job has run",
+				EnvVars: envVars("KEEP_WORKSPACE"),
+			},
+			&cli.DurationFlag{
+				Name:    "kill-grace-period",
+				Value:   defaultKillGracePeriod,
+				Usage:   "time given to job processes to exit after SIGTERM before they are killed",
+				EnvVars: envVars("KILL_GRACE_PERIOD"),
+			},
+			&cli.Uint64Flag{
+				Name:    "limit-nofile",
+				Usage:   "max open files per job process (0 for unlimited)",
+				EnvVars: envVars("LIMIT_NOFILE"),
+			},
+			&cli.Uint64Flag{
+				Name:    "limit-nproc",
+				Usage:   "max processes for the job user and, via cgroup v2, for the job (0 for unlimited)",
+				EnvVars: envVars("LIMIT_NPROC"),
+			},
+			&cli.Uint64Flag{
+				Name:    "limit-core",
+				Usage:   "max core file size in bytes (0 for unlimited)",
+				EnvVars: envVars("LIMIT_CORE"),
+			},
+			&cli.DurationFlag{
+				Name:    "limit-cpu-time",
+				Usage:   "max CPU time for all job processes together, and so for each of them (0 for unlimited)",
+				EnvVars: envVars("LIMIT_CPU_TIME"),
+			},
+			&cli.StringFlag{
+				Name:    "cgroup-parent",
+				Value:   defaultCgroupParent,
+				Usage:   "cgroup v2 group under which per-job cgroups are created",
+				EnvVars: envVars("CGROUP_PARENT"),
+			},
+			&cli.Uint64Flag{
+				Name:    "limit-memory",
+				Usage:   "max memory in bytes for all job processes, via cgroup v2 (0 for unlimited)",
+				EnvVars: envVars("LIMIT_MEMORY"),
+			},
+			&cli.Float64Flag{
+				Name:    "limit-cpus",
+				Usage:   "max CPUs for all job processes, via cgroup v2 (0 for unlimited)",
+				EnvVars: envVars("LIMIT_CPUS"),
+			},
+			&cli.Uint64Flag{
+				Name:    "limit-pids",
+				Usage:   "max number of job processes, via cgroup v2 (0 for unlimited)",
+				EnvVars: envVars("LIMIT_PIDS"),
+			},
+			&cli.StringSliceFlag{
+				Name:    "exit-state",
+				Usage:   "final job state for a script exit code or signal, as <code|SIGNAL>=<state>",
+				EnvVars: envVars("EXIT_STATES"),
+			},
+			&cli.StringFlag{
+				Name:    "warmup-command",
+				Usage:   "command run via \"sh -c\" for warmer jobs instead of the job script",
+				EnvVars: envVars("WARMUP_COMMAND"),
+			},
+			&cli.StringFlag{
+				Name:    "cache-url",
+				Usage:   "file:// URL of a build cache to use instead of the one in the job's cache settings",
+				EnvVars: envVars("CACHE_URL"),
+			},
+			&cli.BoolFlag{
+				Name:    "checkout",
+				Value:   false,
+				Usage:   "clone the job's repository into the build dir at the job's commit before running the script",
+				EnvVars: envVars("CHECKOUT"),
+			},
+			&cli.IntFlag{
+				Name:    "checkout-depth",
+				Value:   defaultCheckoutDepth,
+				Usage:   "number of commits to fetch when checking out, or 0 for full history",
+				EnvVars: envVars("CHECKOUT_DEPTH"),
+			},
+			&cli.StringSliceFlag{
+				Name:    "artifact-path",
+				Usage:   "glob pattern of workspace files to upload to the job's artifacts url after the script has run (may be given multiple times)",
+				EnvVars: envVars("ARTIFACT_PATHS"),
+			},
+			&cli.StringFlag{
+				Name:    "pre-run-hook",
+				Usage:   "command run via sh -c before every job, with the job payload path in TRAVIS_JOB_PAYLOAD; the job errors if it fails",
+				EnvVars: envVars("PRE_RUN_HOOK"),
+			},
+			&cli.StringFlag{
+				Name:    "post-run-hook",
+				Usage:   "command run via sh -c after every job, with the job payload path in TRAVIS_JOB_PAYLOAD and its final state in TRAVIS_JOB_STATE",
+				EnvVars: envVars("POST_RUN_HOOK"),
+			},
+			&cli.BoolFlag{
+				Name:    "pty",
+				Value:   false,
+				Usage:   "run job scripts attached to a pseudo-terminal instead of a pipe",
+				EnvVars: envVars("PTY"),
+			},
+			&cli.UintFlag{
+				Name:    "pty-cols",
+				Value:   defaultPTYColumns,
+				Usage:   "pseudo-terminal width in columns",
+				EnvVars: envVars("PTY_COLS"),
+			},
+			&cli.UintFlag{
+				Name:    "pty-rows",
+				Value:   defaultPTYRows,
+				Usage:   "pseudo-terminal height in rows",
+				EnvVars: envVars("PTY_ROWS"),
+			},
+			&cli.BoolFlag{
+				Name:    "clean-env",
+				Value:   false,
+				Usage:   "start job processes from a minimal environment instead of inheriting this process's",
+				EnvVars: envVars("CLEAN_ENV"),
+			},
+			&cli.BoolFlag{
+				Name:    "echo-env-vars",
+				Value:   false,
+				Usage:   "echo repository env vars at the top of the job log, masking all but public values",
+				EnvVars: envVars("ECHO_ENV_VARS"),
+			},
 		},
 		Commands: []*cli.Command{
 			{
@@ -48,6 +223,16 @@ func NewApp() *cli.App {
 						Usage:   "url for a job",
 						EnvVars: envVars("JOB_URL"),
 					},
+					&cli.StringFlag{
+						Name:    "listen",
+						Usage:   "address to listen on for a job pushed via HTTP instead of polling --url",
+						EnvVars: envVars("LISTEN"),
+					},
+					&cli.StringFlag{
+						Name:    "listen-token",
+						Usage:   "shared token required to push a job via HTTP",
+						EnvVars: envVars("LISTEN_TOKEN"),
+					},
 					&cli.DurationFlag{
 						Name:    "max-wait-time",
 						Value:   30 * time.Minute,
@@ -57,9 +242,39 @@ func NewApp() *cli.App {
 					&cli.DurationFlag{
 						Name:    "wait-interval",
 						Value:   3 * time.Second,
-						Usage:   "interval to sleep between attempts",
+						Usage:   "initial interval to sleep between attempts",
 						EnvVars: envVars("WAIT_INTERVAL"),
 					},
+					&cli.DurationFlag{
+						Name:    "max-wait-interval",
+						Value:   defaultMaxWaitInterval,
+						Usage:   "max interval to back off to between attempts",
+						EnvVars: envVars("MAX_WAIT_INTERVAL"),
+					},
+					&cli.IntFlag{
+						Name:    "max-fetch-errors",
+						Value:   0,
+						Usage:   "number of consecutive failed attempts after which to give up, or 0 for no limit",
+						EnvVars: envVars("MAX_FETCH_ERRORS"),
+					},
+					&cli.BoolFlag{
+						Name:    "continuous",
+						Value:   false,
+						Usage:   "keep fetching and running jobs until max-lifetime, max-jobs or SIGTERM, which lets the current job finish",
+						EnvVars: envVars("CONTINUOUS"),
+					},
+					&cli.IntFlag{
+						Name:    "pool-size",
+						Value:   1,
+						Usage:   "number of jobs to run at once, implying --continuous when more than 1",
+						EnvVars: envVars("POOL_SIZE"),
+					},
+					&cli.IntFlag{
+						Name:    "max-jobs",
+						Value:   0,
+						Usage:   "number of jobs to run in continuous mode before exiting, or 0 for no limit",
+						EnvVars: envVars("MAX_JOBS"),
+					},
 				},
 				Action: waitCommandAction,
 			},
@@ -72,6 +287,12 @@ func NewApp() *cli.App {
 						Usage:   "json input file to run",
 						EnvVars: envVars("JSON"),
 					},
+					&cli.BoolFlag{
+						Name:    "dry-run",
+						Value:   false,
+						Usage:   "validate the job and print how it would be run without running it or reporting on it",
+						EnvVars: envVars("DRY_RUN"),
+					},
 				},
 				Action: runCommandAction,
 			},
@@ -93,14 +314,62 @@ func waitCommandAction(c *cli.Context) error {
 	}
 
 	src := NewRemoteSource(log, c.String("url"), processorID)
+	if c.String("listen") != "" {
+		if c.String("listen-token") == "" {
+			return cli.Exit("refusing to listen for jobs without a --listen-token", 2)
+		}
+
+		src = NewHTTPSource(log, c.String("listen"), c.String("listen-token"), processorID)
+	}
+
+	runnerCfg, err := buildRunnerConfig(c, log)
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("failed to build job runner config: %v", err), 2)
+	}
+
+	statuser := NewStatuser(log)
+	streamer := NewStreamer(log)
+	metrics := startMetrics(ctx, c, log)
+	if metrics != nil {
+		src = NewMetricsSource(src, metrics)
+		statuser = NewMetricsStatuser(statuser, metrics)
+		streamer = NewMetricsStreamer(streamer, metrics)
+	}
+
+	health := startHealth(ctx, c, log, processorID)
+	if health != nil {
+		src = NewHealthSource(src, health)
+		statuser = NewHealthStatuser(statuser, health)
+	}
 
-	runner, err := NewRunner(log, NewStatuser(log), NewStreamer(log))
+	runner, err := NewRunner(log, statuser, streamer, runnerCfg)
 	if err != nil {
 		return cli.Exit(fmt.Sprintf("failed to create job runner: %v", err), 2)
 	}
 
-	w := NewWaiter(log, c.Duration("wait-interval"),
-		c.Duration("max-wait-time"), src, runner)
+	if metrics != nil {
+		runner = NewMetricsRunner(runner, metrics)
+	}
+
+	if health != nil {
+		runner = NewHealthRunner(runner, health)
+	}
+
+	waiterCfg := &WaiterConfig{
+		Interval:       c.Duration("wait-interval"),
+		MaxInterval:    c.Duration("max-wait-interval"),
+		MaxWait:        c.Duration("max-wait-time"),
+		MaxFetchErrors: c.Int("max-fetch-errors"),
+	}
+
+	var w Waiter
+	if c.Bool("continuous") || c.Int("pool-size") > 1 {
+		w = NewPoolWaiter(log, waiterCfg, c.Int("pool-size"), c.Int("max-jobs"),
+			drainOnSignal(log, cancel), src, runner)
+	} else {
+		cancelOnSignal(log, cancel)
+		w = NewWaiter(log, waiterCfg, src, runner)
+	}
 
 	err = w.Wait(ctx)
 
@@ -123,10 +392,43 @@ func runCommandAction(c *cli.Context) error {
 		return cli.Exit(fmt.Sprintf("failed to build processor ID: %v", err), 2)
 	}
 
+	cancelOnSignal(log, cancel)
+
 	src := NewLocalSource(log, c.String("json"), processorID)
 
 	log.Debug("creating job runner")
-	runner, err := NewRunner(log, NewStatuser(log), NewStreamer(log))
+	runnerCfg, err := buildRunnerConfig(c, log)
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("failed to build job runner config: %v", err), 2)
+	}
+
+	var runner Runner
+	if c.Bool("dry-run") {
+		runner, err = NewDryRunner(log, NewDiscardStatuser(log), NewStreamer(log), runnerCfg, os.Stdout)
+	} else {
+		statuser := NewStatuser(log)
+		streamer := NewStreamer(log)
+		metrics := startMetrics(ctx, c, log)
+		if metrics != nil {
+			src = NewMetricsSource(src, metrics)
+			statuser = NewMetricsStatuser(statuser, metrics)
+			streamer = NewMetricsStreamer(streamer, metrics)
+		}
+
+		health := startHealth(ctx, c, log, processorID)
+		if health != nil {
+			src = NewHealthSource(src, health)
+			statuser = NewHealthStatuser(statuser, health)
+		}
+
+		runner, err = NewRunner(log, statuser, streamer, runnerCfg)
+		if err == nil && metrics != nil {
+			runner = NewMetricsRunner(runner, metrics)
+		}
+		if err == nil && health != nil {
+			runner = NewHealthRunner(runner, health)
+		}
+	}
 	if err != nil {
 		return cli.Exit(fmt.Sprintf("failed to create job runner: %v", err), 2)
 	}
@@ -146,6 +448,148 @@ func runCommandAction(c *cli.Context) error {
 	return nil
 }
 
+func buildRunnerConfig(c *cli.Context, log logrus.FieldLogger) (*RunnerConfig, error) {
+	cfg := &RunnerConfig{
+		EchoEnvVars:     c.Bool("echo-env-vars"),
+		Interpreters:    NewInterpreterRegistry(),
+		WorkspaceRoot:   c.String("workspace-root"),
+		KeepWorkspace:   c.Bool("keep-workspace"),
+		KillGracePeriod: c.Duration("kill-grace-period"),
+		Debug:           c.Bool("debug"),
+		DebugHold:       c.Duration("debug-hold"),
+		DebugShell:      c.String("debug-shell"),
+		WarmupCommand:   c.String("warmup-command"),
+		CacheURL:        c.String("cache-url"),
+		Checkout:        c.Bool("checkout"),
+		CheckoutDepth:   c.Int("checkout-depth"),
+		ArtifactPaths:   c.StringSlice("artifact-path"),
+		CleanEnv:        c.Bool("clean-env"),
+		PTY:             c.Bool("pty"),
+		PTYColumns:      uint16(c.Uint("pty-cols")),
+		PTYRows:         uint16(c.Uint("pty-rows")),
+		PreRunHook:      c.String("pre-run-hook"),
+		PostRunHook:     c.String("post-run-hook"),
+		Limits: &ResourceLimits{
+			NoFile:       c.Uint64("limit-nofile"),
+			NProc:        c.Uint64("limit-nproc"),
+			Core:         c.Uint64("limit-core"),
+			CPU:          c.Duration("limit-cpu-time"),
+			CgroupParent: c.String("cgroup-parent"),
+			MemoryMax:    c.Uint64("limit-memory"),
+			CPUs:         c.Float64("limit-cpus"),
+			PidsMax:      c.Uint64("limit-pids"),
+		},
+	}
+
+	cfg.ExitStates = NewExitStates()
+	for _, s := range c.StringSlice("exit-state") {
+		err := cfg.ExitStates.Parse(s)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range c.StringSlice("interpreter") {
+		interp, err := ParseInterpreter(s)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Interpreters.Register(interp)
+	}
+
+	scriptKeys := []*ScriptKey{}
+	for _, s := range c.StringSlice("script-key") {
+		key, err := ParseScriptKey(s)
+		if err != nil {
+			return nil, err
+		}
+		scriptKeys = append(scriptKeys, key)
+	}
+
+	if len(scriptKeys) > 0 || c.Bool("strict-script-signatures") {
+		cfg.ScriptVerifier = NewScriptVerifier(log, c.Bool("strict-script-signatures"), scriptKeys)
+	}
+
+	return cfg, nil
+}
+
+// startHealth reports and serves the processor's health as configured,
+// returning nil if neither is.
+func startHealth(ctx context.Context, c *cli.Context, log logrus.FieldLogger, processorID string) *Health {
+	if c.String("health-url") == "" && c.String("health-listen") == "" {
+		return nil
+	}
+
+	health := NewHealth(processorID)
+
+	if c.String("health-url") != "" {
+		go health.Report(ctx, log, c.String("health-url"), c.Duration("health-interval"))
+	}
+
+	if c.String("health-listen") != "" {
+		go func() {
+			err := health.Serve(ctx, log, c.String("health-listen"))
+			if err != nil {
+				log.WithError(err).Error("failed to serve health checks")
+			}
+		}()
+	}
+
+	return health
+}
+
+// startMetrics serves the processor's metrics as configured, returning nil if
+// they are not.
+func startMetrics(ctx context.Context, c *cli.Context, log logrus.FieldLogger) *Metrics {
+	if c.String("metrics-listen") == "" {
+		return nil
+	}
+
+	metrics := NewMetrics()
+
+	go func() {
+		err := metrics.Serve(ctx, log, c.String("metrics-listen"))
+		if err != nil {
+			log.WithError(err).Error("failed to serve metrics")
+		}
+	}()
+
+	return metrics
+}
+
+// drainOnSignal returns a channel that is closed on the first SIGTERM or
+// interrupt.  A second one cancels outright.
+func drainOnSignal(log logrus.FieldLogger, cancel context.CancelFunc) <-chan struct{} {
+	drain := make(chan struct{})
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGTERM, os.Interrupt)
+
+	go func() {
+		sig := <-sigs
+		log.WithField("signal", sig).Info("draining, signal again to stop now")
+		close(drain)
+
+		sig = <-sigs
+		log.WithField("signal", sig).Warn("stopping")
+		cancel()
+	}()
+
+	return drain
+}
+
+// cancelOnSignal cancels on SIGTERM or interrupt, so that a running job is
+// stopped and cleaned up rather than orphaned.
+func cancelOnSignal(log logrus.FieldLogger, cancel context.CancelFunc) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, os.Interrupt)
+
+	go func() {
+		sig := <-sigs
+		log.WithField("signal", sig).Warn("stopping")
+		cancel()
+	}()
+}
+
 func setupLogger(debug bool) logrus.FieldLogger {
 	log := logrus.New()
 	if debug {
@@ -0,0 +1,42 @@
+package job
+
+import (
+	"context"
+	"io"
+	"os/exec"
+
+	"github.com/sirupsen/logrus"
+)
+
+// runShellCommand runs an operator-supplied command line via "sh -c" in its
+// own process group, writing its combined output to out.  If the context is
+// done first, the process group is stopped, and output still held open by
+// background processes is cut off after the kill grace period.
+func (er *execRunner) runShellCommand(ctx context.Context, log logrus.FieldLogger, command, dir string, env []string, out io.Writer) error {
+	log.WithField("command", command).Debug("running shell command")
+	return er.runCommand(ctx, log, exec.Command("sh", "-c", command), dir, env, out)
+}
+
+// runCommand runs cmd like runShellCommand runs its command line.
+func (er *execRunner) runCommand(ctx context.Context, log logrus.FieldLogger, cmd *exec.Cmd, dir string, env []string, out io.Writer) error {
+	cmd.Dir = dir
+	cmd.Env = env
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.WaitDelay = er.cfg.KillGracePeriod
+	setProcessGroup(cmd)
+
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	err = er.wait(ctx, log, cmd)
+
+	stopErr := newProcessGroup(cmd.Process).stop(er.cfg.KillGracePeriod)
+	if stopErr != nil {
+		log.WithError(stopErr).Warn("failed to stop remaining command processes")
+	}
+
+	return err
+}
@@ -0,0 +1,120 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	debugHoldSocketName = "debug.sock"
+	defaultDebugShell   = "bash"
+)
+
+// debugHold keeps a finished job's workspace and environment around and
+// serves interactive shells in them over a unix socket in the workspace.  The
+// hold ends when the timeout passes, the context is done or the first shell
+// session exits.
+type debugHold struct {
+	log     logrus.FieldLogger
+	shell   string
+	timeout time.Duration
+	ws      *workspace
+	env     []string
+
+	mu       sync.Mutex
+	sessions []*exec.Cmd
+}
+
+func (dh *debugHold) run(ctx context.Context, out io.Writer) error {
+	sockPath := filepath.Join(dh.ws.dir, debugHoldSocketName)
+	listener, err := net.Listen("unix", sockPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to listen for debug sessions")
+	}
+
+	defer listener.Close()
+
+	err = os.Chmod(sockPath, os.FileMode(0600))
+	if err != nil {
+		return errors.Wrap(err, "failed to restrict debug socket permissions")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dh.timeout)
+	defer cancel()
+
+	dh.log.WithFields(logrus.Fields{
+		"socket":  sockPath,
+		"timeout": dh.timeout,
+	}).Info("holding job for debugging")
+
+	fmt.Fprintf(out, "\n\nThe job is being held for debugging for %v.  Connect to a shell with:\n\n", dh.timeout)
+	fmt.Fprintf(out, "  socat - UNIX-CONNECT:%s\n\n", sockPath)
+	fmt.Fprintf(out, "The hold ends when the first shell exits.\n")
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				dh.session(conn)
+				cancel()
+			}()
+		}
+	}()
+
+	<-ctx.Done()
+	dh.stopSessions()
+
+	fmt.Fprintf(out, "\nThe debug hold has ended.\n")
+	return nil
+}
+
+func (dh *debugHold) session(conn net.Conn) {
+	defer conn.Close()
+
+	cmd := exec.Command(dh.shell, "-i")
+	cmd.Dir = dh.ws.buildDir
+	cmd.Env = dh.env
+	cmd.Stdin = conn
+	cmd.Stdout = conn
+	cmd.Stderr = conn
+	setProcessGroup(cmd)
+
+	dh.log.Info("starting debug session")
+	dh.mu.Lock()
+	err := cmd.Start()
+	if err == nil {
+		dh.sessions = append(dh.sessions, cmd)
+	}
+	dh.mu.Unlock()
+
+	if err != nil {
+		dh.log.WithError(err).Error("failed to start debug session")
+		return
+	}
+
+	err = cmd.Wait()
+	dh.log.WithField("err", err).Info("debug session ended")
+}
+
+func (dh *debugHold) stopSessions() {
+	dh.mu.Lock()
+	defer dh.mu.Unlock()
+
+	for _, cmd := range dh.sessions {
+		_ = newProcessGroup(cmd.Process).kill()
+	}
+}
@@ -0,0 +1,291 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// NewDryRunner builds a Runner that checks everything needed to run a job
+// without running it: the job is validated, its script is decoded and
+// verified, its URL templates are resolved and its state and streams are sent
+// through the given statuser and streamer.  The environment and command the
+// job would be run with, or for warmer jobs the warm-up command, are written
+// to out.
+func NewDryRunner(log logrus.FieldLogger, statuser Statuser, streamer Streamer, cfg *RunnerConfig, out io.Writer) (Runner, error) {
+	er, err := newExecRunner(log, statuser, streamer, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dryRunner{
+		er:  er,
+		log: log.WithField("self", "dry_runner"),
+		out: out,
+	}, nil
+}
+
+type dryRunner struct {
+	er  *execRunner
+	log logrus.FieldLogger
+	out io.Writer
+}
+
+func (dr *dryRunner) Run(ctx context.Context, job Job) error {
+	log := dr.log.WithField("job_id", job.ID())
+
+	log.Debug("validating job")
+	err := validateJob(ctx, job)
+	if err != nil {
+		return errors.Wrap(err, "invalid job")
+	}
+
+	script, err := job.Script(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to extract job script")
+	}
+
+	warmer := job.Metadata().Warmer
+
+	var interp *Interpreter
+	if !warmer {
+		if dr.er.cfg.ScriptVerifier != nil {
+			err = dr.er.cfg.ScriptVerifier.Verify(job, script)
+			if err != nil {
+				return errors.Wrap(err, "failed to verify job script")
+			}
+		}
+
+		interp, err = dr.er.cfg.Interpreters.Select(job, script)
+		if err != nil {
+			return errors.Wrap(err, "failed to select job script interpreter")
+		}
+	}
+
+	fmt.Fprintf(dr.out, "job:            %s\n", job.ID())
+	if warmer {
+		fmt.Fprintf(dr.out, "script:         not run, the job is a warmer\n")
+	} else {
+		fmt.Fprintf(dr.out, "script:         %d bytes, run with %s\n", len(script), interp.Name)
+	}
+
+	urls := []struct {
+		name, template string
+		vars           map[string]interface{}
+	}{
+		{"job_state_url", job.JobStateURL(), nil},
+		{"log_parts_url", job.LogPartsURL(), nil},
+		{"artifacts_url", job.ArtifactsURL(), map[string]interface{}{"path": "build/example.log"}},
+	}
+
+	for _, u := range urls {
+		if u.template == "" {
+			fmt.Fprintf(dr.out, "%-15s (none)\n", u.name+":")
+			continue
+		}
+
+		expanded, err := expandJobURL(u.template, job, u.vars)
+		if err != nil {
+			return errors.Wrapf(err, "invalid %s", u.name)
+		}
+		fmt.Fprintf(dr.out, "%-15s %s\n", u.name+":", expanded)
+	}
+
+	log.Debug("exercising statuser")
+	err = dr.er.statuser.Status(ctx, job, NewStateUpdate(job.ID(), QueuedState, ReceivedState))
+	if err != nil {
+		return errors.Wrap(err, "failed to set job status")
+	}
+
+	log.Debug("exercising streamer")
+	for _, str := range job.Streams() {
+		err = dr.exerciseStream(ctx, job, str)
+		if err != nil {
+			return errors.Wrapf(err, "failed to stream %s", str.Name())
+		}
+	}
+
+	root := dr.er.cfg.WorkspaceRoot
+	if root == "" {
+		root = os.TempDir()
+	}
+
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return errors.Wrap(err, "failed to find absolute workspace root")
+	}
+
+	dir := filepath.Join(root, fmt.Sprintf("travis-job-%s-XXXXXX", job.ID()))
+	ws := &workspace{
+		dir:      dir,
+		buildDir: filepath.Join(dir, workspaceBuildDirName),
+		jobID:    job.ID(),
+	}
+
+	jc, err := newJobCache(job, ws, dr.er.cfg.CacheURL)
+	switch {
+	case err != nil:
+		fmt.Fprintf(dr.out, "cache:          not used: %v\n", err)
+	case jc == nil:
+		fmt.Fprintf(dr.out, "cache:          (none)\n")
+	case warmer:
+		fmt.Fprintf(dr.out, "cache:          warm %s\n", strings.Join(jc.keys, ", "))
+	default:
+		fmt.Fprintf(dr.out, "cache:          %s\n", strings.Join(jc.keys, ", "))
+	}
+
+	if warmer {
+		if dr.er.cfg.WarmupCommand == "" {
+			fmt.Fprintf(dr.out, "command:        (none)\n")
+			return nil
+		}
+
+		dr.writeEnviron(job, dr.er.warmupEnviron(job, ws))
+		fmt.Fprintf(dr.out, "command:        %s\n", shellQuote([]string{"sh", "-c", dr.er.cfg.WarmupCommand}))
+		return nil
+	}
+
+	sc := dr.er.command(log, job, interp, ws, jc)
+	dr.writeEnviron(job, sc.env)
+	fmt.Fprintf(dr.out, "command:        %s\n", shellQuote(append([]string{sc.name}, sc.args...)))
+	return nil
+}
+
+// writeEnviron lists env as the job's processes would see it.  Variables
+// passed on unchanged from travis-job's own environment are only counted, and
+// repository env vars are listed as they would be echoed, so that only public
+// values are shown.
+func (dr *dryRunner) writeEnviron(job Job, env []string) {
+	inherited := map[string]bool{}
+	for _, kv := range dr.er.baseEnviron() {
+		inherited[kv] = true
+	}
+
+	masked := map[string]string{}
+	for _, ev := range job.EnvVars() {
+		masked[fmt.Sprintf("%s=%s", ev.Name, ev.Value)] = ev.String()
+	}
+
+	fmt.Fprintln(dr.out, "environment:")
+	unchanged := 0
+	for _, kv := range dedupeEnviron(env) {
+		if inherited[kv] {
+			unchanged++
+			continue
+		}
+
+		if m, ok := masked[kv]; ok {
+			kv = m
+		}
+		fmt.Fprintf(dr.out, "  %s\n", kv)
+	}
+
+	if unchanged > 0 {
+		fmt.Fprintf(dr.out, "  (%d more inherited from travis-job's environment)\n", unchanged)
+	}
+}
+
+// dedupeEnviron keeps only the last value of each variable in env, in its
+// place, as os/exec does when starting a command.
+func dedupeEnviron(env []string) []string {
+	seen := map[string]bool{}
+	deduped := []string{}
+	for i := len(env) - 1; i >= 0; i-- {
+		name := strings.SplitN(env[i], "=", 2)[0]
+		if seen[name] {
+			continue
+		}
+
+		seen[name] = true
+		deduped = append(deduped, env[i])
+	}
+
+	for i, j := 0, len(deduped)-1; i < j; i, j = i+1, j-1 {
+		deduped[i], deduped[j] = deduped[j], deduped[i]
+	}
+
+	return deduped
+}
+
+// exerciseStream streams a single line through the streamer into a discarding
+// sink.
+func (dr *dryRunner) exerciseStream(ctx context.Context, job Job, str Stream) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pr, pw := io.Pipe()
+	str.SetSource(pr)
+	str.SetDest(ioutil.Discard)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- dr.er.streamer.Stream(ctx, job, str)
+	}()
+
+	_, err := fmt.Fprintf(pw, "travis-job dry run of job %s\n", job.ID())
+	pw.Close()
+	if err != nil {
+		return err
+	}
+
+	cancel()
+	err = <-errs
+	if err == context.Canceled {
+		return nil
+	}
+
+	return err
+}
+
+// discardStatuser checks state updates for a job as the URL statuser would,
+// but does not send them anywhere.
+type discardStatuser struct {
+	log logrus.FieldLogger
+}
+
+// NewDiscardStatuser builds a Statuser that resolves each job's state URL
+// and logs state updates instead of sending them.
+func NewDiscardStatuser(log logrus.FieldLogger) Statuser {
+	return &discardStatuser{log: log.WithField("self", "discard_statuser")}
+}
+
+func (ds *discardStatuser) Status(ctx context.Context, job Job, stateUpdate StateUpdate) error {
+	u, err := expandJobURL(job.JobStateURL(), job, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = json.Marshal(stateUpdate)
+	if err != nil {
+		return errors.Wrap(err, "error encoding json")
+	}
+
+	ds.log.WithFields(logrus.Fields{
+		"job_id":    job.ID(),
+		"url":       u.String(),
+		"cur_state": stateUpdate.Cur(),
+		"new_state": stateUpdate.New(),
+	}).Debug("discarding state update")
+	return nil
+}
+
+func shellQuote(words []string) string {
+	quoted := []string{}
+	for _, w := range words {
+		if w != "" && strings.Trim(w, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./:=@%+,") == "" {
+			quoted = append(quoted, w)
+			continue
+		}
+		quoted = append(quoted, "'"+strings.Replace(w, "'", `'\''`, -1)+"'")
+	}
+
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,105 @@
+package job
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestDedupeEnviron(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		env      []string
+		expected []string
+	}{
+		{"empty", []string{}, []string{}},
+		{"unique", []string{"A=1", "B=2"}, []string{"A=1", "B=2"}},
+		{"last wins in its place", []string{"HOME=/root", "A=1", "HOME=/tmp/ws", "B=2"}, []string{"A=1", "HOME=/tmp/ws", "B=2"}},
+		{"empty value wins", []string{"A=1", "A="}, []string{"A="}},
+		{"value with equals", []string{"A=x=1", "A=x=2"}, []string{"A=x=2"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := dedupeEnviron(tc.env)
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestDryRunner(t *testing.T) {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+
+	for _, tc := range []struct {
+		name     string
+		warmer   bool
+		warmup   string
+		contains []string
+		excludes []string
+	}{
+		{
+			name:     "job",
+			contains: []string{"run with bash", "HOME=", "TRAVIS_JOB_ID=42", "FOO=bar", "TOKEN=[secure]", "command:        bash "},
+			excludes: []string{"hunter2", "inherited HOME"},
+		},
+		{
+			name:     "warmer",
+			warmer:   true,
+			warmup:   "docker pull example",
+			contains: []string{"not run, the job is a warmer", "TRAVIS_JOB_ID=42", "command:        sh -c 'docker pull example'"},
+			excludes: []string{"run with bash", "FOO=bar"},
+		},
+		{
+			name:     "warmer without warm-up command",
+			warmer:   true,
+			contains: []string{"not run, the job is a warmer", "command:        (none)"},
+			excludes: []string{"environment:"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("HOME", "/inherited HOME")
+
+			j := &jobWrapper{J: &job{
+				Data: &jobData{
+					Job:    &jobDataJob{ID: 42},
+					Warmer: tc.warmer,
+					EnvVars: []*jobDataEnvVar{
+						{Name: "FOO", Value: "bar", Public: true},
+						{Name: "TOKEN", Value: "hunter2", Secure: true},
+					},
+				},
+				JobScript:   &jobJobScript{Encoding: "plain", Content: "echo hello\n"},
+				JobStateURL: "file:///dev/null",
+			}}
+
+			out := &bytes.Buffer{}
+			cfg := &RunnerConfig{WorkspaceRoot: t.TempDir(), WarmupCommand: tc.warmup}
+			runner, err := NewDryRunner(log, &testStatuser{}, testStreamer{}, cfg, out)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = runner.Run(context.Background(), j)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for _, s := range tc.contains {
+				if !strings.Contains(out.String(), s) {
+					t.Errorf("expected output to contain %q:\n%s", s, out)
+				}
+			}
+			for _, s := range tc.excludes {
+				if strings.Contains(out.String(), s) {
+					t.Errorf("expected output not to contain %q:\n%s", s, out)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package job
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	secureEnvVarMask = "[secure]"
+
+	// secretMaskingBufferSize bounds how much output is held back while
+	// waiting for a line ending before it is masked and written anyway.
+	secretMaskingBufferSize = 32 * 1024
+)
+
+// EnvVar is a repository environment variable from a job's data.env_vars.
+// Secure values must never be logged and are masked in job output.  Only
+// public values are ever shown as they are.
+type EnvVar struct {
+	Name   string
+	Value  string
+	Public bool
+	Secure bool
+}
+
+func (ev EnvVar) String() string {
+	if ev.Secure || !ev.Public {
+		return fmt.Sprintf("%s=%s", ev.Name, secureEnvVarMask)
+	}
+
+	return fmt.Sprintf("%s=%s", ev.Name, ev.Value)
+}
+
+func envVarsEnviron(envVars []EnvVar) []string {
+	environ := []string{}
+	for _, ev := range envVars {
+		environ = append(environ, fmt.Sprintf("%s=%s", ev.Name, ev.Value))
+	}
+
+	return environ
+}
+
+func envVarsSecrets(envVars []EnvVar) []string {
+	secrets := []string{}
+	for _, ev := range envVars {
+		if ev.Secure && ev.Value != "" {
+			secrets = append(secrets, ev.Value)
+		}
+	}
+
+	return secrets
+}
+
+func writeEnvVarsEcho(w io.Writer, envVars []EnvVar) error {
+	if len(envVars) == 0 {
+		return nil
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintln(buf, "Setting environment variables from repository settings")
+	for _, ev := range envVars {
+		fmt.Fprintf(buf, "$ export %s\n", ev)
+	}
+	fmt.Fprintln(buf)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// maskSecrets wraps w in a secretMaskingWriter if there are any secrets to
+// mask, and returns the writer along with the function that flushes it.
+func maskSecrets(w io.Writer, secrets []string) (io.Writer, func() error) {
+	if len(secrets) == 0 {
+		return w, func() error { return nil }
+	}
+
+	smw := newSecretMaskingWriter(w, secrets)
+	return smw, smw.Flush
+}
+
+// secretMaskingWriter replaces secret values in everything written through it
+// with secureEnvVarMask.  Output is held back until a line ending so that
+// secrets split across writes are still masked; call Flush once the writer is
+// no longer in use.
+type secretMaskingWriter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	replacer *strings.Replacer
+	buf      []byte
+}
+
+func newSecretMaskingWriter(w io.Writer, secrets []string) *secretMaskingWriter {
+	sorted := append([]string{}, secrets...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return len(sorted[i]) > len(sorted[j])
+	})
+
+	pairs := []string{}
+	for _, secret := range sorted {
+		pairs = append(pairs, secret, secureEnvVarMask)
+	}
+
+	return &secretMaskingWriter{
+		w:        w,
+		replacer: strings.NewReplacer(pairs...),
+	}
+}
+
+func (smw *secretMaskingWriter) Write(p []byte) (int, error) {
+	smw.mu.Lock()
+	defer smw.mu.Unlock()
+
+	smw.buf = append(smw.buf, p...)
+
+	n := bytes.LastIndexAny(smw.buf, "\r\n") + 1
+	if n == 0 && len(smw.buf) >= secretMaskingBufferSize {
+		n = len(smw.buf)
+	}
+
+	if n == 0 {
+		return len(p), nil
+	}
+
+	err := smw.writeMasked(smw.buf[:n])
+	smw.buf = append(smw.buf[:0], smw.buf[n:]...)
+	return len(p), err
+}
+
+func (smw *secretMaskingWriter) Flush() error {
+	smw.mu.Lock()
+	defer smw.mu.Unlock()
+
+	if len(smw.buf) == 0 {
+		return nil
+	}
+
+	err := smw.writeMasked(smw.buf)
+	smw.buf = smw.buf[:0]
+	return err
+}
+
+func (smw *secretMaskingWriter) writeMasked(b []byte) error {
+	_, err := io.WriteString(smw.w, smw.replacer.Replace(string(b)))
+	return err
+}
@@ -0,0 +1,168 @@
+package job
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEnvVarString(t *testing.T) {
+	for _, tc := range []struct {
+		ev       EnvVar
+		expected string
+	}{
+		{EnvVar{Name: "PUB", Value: "shown", Public: true}, "PUB=shown"},
+		{EnvVar{Name: "HID", Value: "hidden"}, "HID=[secure]"},
+		{EnvVar{Name: "SEC", Value: "hidden", Secure: true}, "SEC=[secure]"},
+		{EnvVar{Name: "BOTH", Value: "hidden", Public: true, Secure: true}, "BOTH=[secure]"},
+	} {
+		if actual := tc.ev.String(); actual != tc.expected {
+			t.Errorf("expected %q, got %q", tc.expected, actual)
+		}
+	}
+}
+
+func TestWriteEnvVarsEcho(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := writeEnvVarsEcho(buf, []EnvVar{
+		{Name: "PUB", Value: "shown", Public: true},
+		{Name: "HID", Value: "notpublic"},
+		{Name: "SEC", Value: "s3cr3t", Secure: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{"notpublic", "s3cr3t"} {
+		if strings.Contains(buf.String(), s) {
+			t.Errorf("echo leaked %q:\n%s", s, buf.String())
+		}
+	}
+
+	for _, s := range []string{"$ export PUB=shown\n", "$ export HID=[secure]\n", "$ export SEC=[secure]\n"} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("echo is missing %q:\n%s", s, buf.String())
+		}
+	}
+}
+
+func TestSecretMaskingWriter(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		secrets  []string
+		writes   []string
+		expected string
+	}{
+		{
+			name:     "whole secret",
+			secrets:  []string{"s3cr3t"},
+			writes:   []string{"token is s3cr3t\n"},
+			expected: "token is [secure]\n",
+		},
+		{
+			name:     "secret split across writes",
+			secrets:  []string{"s3cr3t"},
+			writes:   []string{"token is s3", "cr", "3t\n"},
+			expected: "token is [secure]\n",
+		},
+		{
+			name:     "secret split across writes ending in a carriage return",
+			secrets:  []string{"s3cr3t"},
+			writes:   []string{"progress s3c", "r3t\r", "progress s3cr3t\r"},
+			expected: "progress [secure]\rprogress [secure]\r",
+		},
+		{
+			name:     "secret after a carriage return",
+			secrets:  []string{"s3cr3t"},
+			writes:   []string{"10%\rs3c", "r3t 20%\n"},
+			expected: "10%\r[secure] 20%\n",
+		},
+		{
+			name:     "secret in an unterminated final line",
+			secrets:  []string{"s3cr3t"},
+			writes:   []string{"done s3c", "r3t"},
+			expected: "done [secure]",
+		},
+		{
+			name:     "longest secret first",
+			secrets:  []string{"abc", "abcdef"},
+			writes:   []string{"abcdef abc\n"},
+			expected: "[secure] [secure]\n",
+		},
+		{
+			name:     "several secrets on several lines",
+			secrets:  []string{"one", "two"},
+			writes:   []string{"on", "e\ntw", "o\nthree\n"},
+			expected: "[secure]\n[secure]\nthree\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			w, flush := maskSecrets(buf, tc.secrets)
+
+			for _, s := range tc.writes {
+				n, err := w.Write([]byte(s))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n != len(s) {
+					t.Fatalf("expected to write %d bytes, wrote %d", len(s), n)
+				}
+			}
+
+			err := flush()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if buf.String() != tc.expected {
+				t.Fatalf("expected %q, got %q", tc.expected, buf.String())
+			}
+		})
+	}
+}
+
+func TestSecretMaskingWriterHoldsBackPartialLines(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, _ := maskSecrets(buf, []string{"s3cr3t"})
+
+	_, _ = w.Write([]byte("line\npartial s3c"))
+	if buf.String() != "line\n" {
+		t.Fatalf("expected only complete lines to be written, got %q", buf.String())
+	}
+}
+
+func TestSecretMaskingWriterBufferLimit(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, _ := maskSecrets(buf, []string{"s3cr3t"})
+
+	long := strings.Repeat("x", secretMaskingBufferSize)
+	_, _ = w.Write([]byte(long))
+	if buf.Len() != len(long) {
+		t.Fatalf("expected a full buffer without line endings to be written, got %d bytes", buf.Len())
+	}
+}
+
+func TestMaskSecretsWithoutSecrets(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, flush := maskSecrets(buf, nil)
+	if w != buf {
+		t.Fatalf("expected writer to be passed through unwrapped")
+	}
+
+	if err := flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEnvVarsSecrets(t *testing.T) {
+	secrets := envVarsSecrets([]EnvVar{
+		{Name: "PUB", Value: "shown", Public: true},
+		{Name: "SEC", Value: "s3cr3t", Secure: true},
+		{Name: "EMPTY", Value: "", Secure: true},
+	})
+
+	if len(secrets) != 1 || secrets[0] != "s3cr3t" {
+		t.Fatalf("expected only the non-empty secure value, got %q", secrets)
+	}
+}
@@ -0,0 +1,75 @@
+package job
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ExitStates maps the exit code or terminating signal of a job process to the
+// job's final state.  Unmapped exits are passed for code 0, failed for any
+// other code and errored for signals.
+type ExitStates struct {
+	Codes   map[int]State
+	Signals map[string]State
+}
+
+func NewExitStates() *ExitStates {
+	return &ExitStates{
+		Codes:   map[int]State{},
+		Signals: map[string]State{},
+	}
+}
+
+// Parse adds a mapping in the form "<code>=<state>" or "<SIGNAL>=<state>",
+// e.g. "75=restarted" or "SIGKILL=errored".
+func (es *ExitStates) Parse(s string) error {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid exit state mapping %q", s)
+	}
+
+	state := State(strings.TrimSpace(parts[1]))
+	switch state {
+	case PassedState, FailedState, ErroredState, CanceledState, RestartedState:
+	default:
+		return fmt.Errorf("invalid final state %q in exit state mapping %q", state, s)
+	}
+
+	key := strings.ToUpper(strings.TrimSpace(parts[0]))
+	if strings.HasPrefix(key, "SIG") {
+		es.Signals[key] = state
+		return nil
+	}
+
+	code, err := strconv.Atoi(key)
+	if err != nil {
+		return fmt.Errorf("invalid exit code in exit state mapping %q", s)
+	}
+
+	es.Codes[code] = state
+	return nil
+}
+
+func (es *ExitStates) state(exitCode int, signal string) State {
+	if signal != "" {
+		if es != nil {
+			if state, ok := es.Signals[signal]; ok {
+				return state
+			}
+		}
+		return ErroredState
+	}
+
+	if es != nil {
+		if state, ok := es.Codes[exitCode]; ok {
+			return state
+		}
+	}
+
+	if exitCode == 0 {
+		return PassedState
+	}
+
+	return FailedState
+}
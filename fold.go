@@ -0,0 +1,16 @@
+package job
+
+import (
+	"fmt"
+	"io"
+)
+
+// foldStart and foldEnd write the markers that make the Travis log viewer
+// fold the output between them under a collapsible heading.
+func foldStart(w io.Writer, name string) {
+	fmt.Fprintf(w, "travis_fold:start:%s\r\033[0K", name)
+}
+
+func foldEnd(w io.Writer, name string) {
+	fmt.Fprintf(w, "\ntravis_fold:end:%s\r\033[0K", name)
+}
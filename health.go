@@ -0,0 +1,331 @@
+package job
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultHealthInterval = 30 * time.Second
+
+	// healthStaleAfter is how long an idle processor may go without
+	// attempting to fetch a job before it is considered wedged.
+	healthStaleAfter = 10 * time.Minute
+)
+
+// Health tracks what a processor is doing, as seen through the Source, Runner
+// and Statuser wrapped by NewHealthSource, NewHealthRunner and
+// NewHealthStatuser, for reporting to a health URL or serving on /healthz and
+// /readyz.
+type Health struct {
+	mu          sync.Mutex
+	processorID string
+	startedAt   time.Time
+	jobs        map[string]State
+	fetching    int
+	lastFetch   *healthFetch
+}
+
+type healthFetch struct {
+	at     time.Time
+	result string
+	err    error
+}
+
+type healthReport struct {
+	ProcessorID string             `json:"processor_id"`
+	Uptime      float64            `json:"uptime_seconds"`
+	Jobs        []healthReportJob  `json:"jobs"`
+	LastFetch   *healthReportFetch `json:"last_fetch"`
+	Healthy     bool               `json:"healthy"`
+	Ready       bool               `json:"ready"`
+}
+
+type healthReportJob struct {
+	ID    string `json:"id"`
+	State State  `json:"state"`
+}
+
+type healthReportFetch struct {
+	At     time.Time `json:"at"`
+	Result string    `json:"result"`
+	Error  string    `json:"error,omitempty"`
+}
+
+func NewHealth(processorID string) *Health {
+	return &Health{
+		processorID: processorID,
+		startedAt:   time.Now(),
+		jobs:        map[string]State{},
+	}
+}
+
+func (h *Health) startFetch() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.fetching++
+}
+
+// finishFetch records the result of a fetch, unless it was cut short by its
+// context being done.
+func (h *Health) finishFetch(err error, cutShort bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.fetching--
+	if cutShort {
+		return
+	}
+
+	fetch := &healthFetch{at: time.Now(), result: "job"}
+	switch {
+	case err == nil:
+	case errors.Cause(err) == remoteSourceNoJobErr:
+		fetch.result = "no_job"
+	default:
+		fetch.result = "error"
+		fetch.err = err
+	}
+
+	h.lastFetch = fetch
+}
+
+func (h *Health) recordState(job Job, state State) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.jobs[job.ID()]; ok {
+		h.jobs[job.ID()] = state
+	}
+}
+
+func (h *Health) startJob(job Job) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.jobs[job.ID()] = QueuedState
+}
+
+func (h *Health) finishJob(job Job) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.jobs, job.ID())
+}
+
+// report describes the processor's health.  It is healthy unless it is
+// neither running nor fetching a job and has not tried to fetch one for
+// healthStaleAfter, and ready unless its last fetch failed.
+func (h *Health) report() *healthReport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	report := &healthReport{
+		ProcessorID: h.processorID,
+		Uptime:      now.Sub(h.startedAt).Seconds(),
+		Jobs:        []healthReportJob{},
+		Healthy:     true,
+		Ready:       true,
+	}
+
+	for id, state := range h.jobs {
+		report.Jobs = append(report.Jobs, healthReportJob{ID: id, State: state})
+	}
+	sort.Slice(report.Jobs, func(i, j int) bool { return report.Jobs[i].ID < report.Jobs[j].ID })
+
+	lastActive := h.startedAt
+	if h.lastFetch != nil {
+		lastActive = h.lastFetch.at
+		report.LastFetch = &healthReportFetch{At: h.lastFetch.at, Result: h.lastFetch.result}
+		if h.lastFetch.err != nil {
+			report.LastFetch.Error = h.lastFetch.err.Error()
+			report.Ready = false
+		}
+	}
+
+	if len(h.jobs) == 0 && h.fetching == 0 && now.Sub(lastActive) > healthStaleAfter {
+		report.Healthy = false
+	}
+
+	return report
+}
+
+// Report sends the processor's health to healthURL every interval until the
+// context is done.  Failures are logged and retried at the next interval.
+func (h *Health) Report(ctx context.Context, log logrus.FieldLogger, healthURL string, interval time.Duration) {
+	log = log.WithField("self", "health_reporter")
+	if interval <= 0 {
+		interval = defaultHealthInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := h.send(ctx, healthURL)
+		if err != nil && ctx.Err() == nil {
+			log.WithError(err).Warn("failed to report health")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *Health) send(ctx context.Context, healthURL string) error {
+	u, err := url.Parse(healthURL)
+	if err != nil {
+		return errors.Wrap(err, "couldn't parse health URL")
+	}
+
+	body, err := json.Marshal(h.report())
+	if err != nil {
+		return errors.Wrap(err, "error encoding json")
+	}
+
+	switch u.Scheme {
+	case "file":
+		dest, err := filepath.Abs(u.Host + u.Path)
+		if err != nil {
+			return errors.Wrap(err, "failed to find absolute dest path")
+		}
+		return ioutil.WriteFile(dest, append(body, '\n'), os.FileMode(0644))
+	case "http", "https":
+		req, err := http.NewRequest("POST", u.String(), bytes.NewReader(body))
+		if err != nil {
+			return errors.Wrap(err, "couldn't create request")
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return errors.Wrap(err, "error making health report request")
+		}
+
+		defer resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return errors.Errorf("expected 2xx, but got %d", resp.StatusCode)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unknown scheme %v", u.Scheme)
+	}
+}
+
+// Serve serves the processor's health on /healthz and its readiness on
+// /readyz at listenAddr until the context is done.  Both respond with the
+// health report, with a 503 status when unhealthy or not ready.
+func (h *Health) Serve(ctx context.Context, log logrus.FieldLogger, listenAddr string) error {
+	log = log.WithField("self", "health_server")
+
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return errors.Wrap(err, "failed to listen for health checks")
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
+		report := h.report()
+		h.writeReport(w, report, report.Healthy)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, req *http.Request) {
+		report := h.report()
+		h.writeReport(w, report, report.Healthy && report.Ready)
+	})
+
+	server := &http.Server{Handler: mux}
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+
+	log.WithField("addr", listener.Addr().String()).Info("serving health checks")
+	err = server.Serve(listener)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+
+	return err
+}
+
+func (h *Health) writeReport(w http.ResponseWriter, report *healthReport, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+// NewHealthSource wraps a Source, recording the result of every fetch in h.
+func NewHealthSource(src Source, h *Health) Source {
+	return &healthSource{src: src, health: h}
+}
+
+type healthSource struct {
+	src    Source
+	health *Health
+}
+
+func (hs *healthSource) Fetch(ctx context.Context) (Job, error) {
+	hs.health.startFetch()
+	job, err := hs.src.Fetch(ctx)
+	hs.health.finishFetch(err, ctx.Err() != nil)
+
+	return job, err
+}
+
+// NewHealthRunner wraps a Runner, recording the jobs it is running in h.
+func NewHealthRunner(runner Runner, h *Health) Runner {
+	return &healthRunner{runner: runner, health: h}
+}
+
+type healthRunner struct {
+	runner Runner
+	health *Health
+}
+
+func (hr *healthRunner) Run(ctx context.Context, job Job) error {
+	hr.health.startJob(job)
+	defer hr.health.finishJob(job)
+
+	return hr.runner.Run(ctx, job)
+}
+
+// NewHealthStatuser wraps a Statuser, recording the state of every running
+// job it reports on in h.
+func NewHealthStatuser(statuser Statuser, h *Health) Statuser {
+	return &healthStatuser{statuser: statuser, health: h}
+}
+
+type healthStatuser struct {
+	statuser Statuser
+	health   *Health
+}
+
+func (hs *healthStatuser) Status(ctx context.Context, job Job, stateUpdate StateUpdate) error {
+	hs.health.recordState(job, stateUpdate.New())
+	return hs.statuser.Status(ctx, job, stateUpdate)
+}
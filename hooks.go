@@ -0,0 +1,138 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	preRunHookStreamName  = "pre_run_hook"
+	postRunHookStreamName = "post_run_hook"
+
+	// postRunHookTimeout bounds the post-run hook of a job whose context is
+	// already done.
+	postRunHookTimeout = 10 * time.Minute
+)
+
+// runWithHooks runs a received job between the configured pre- and post-run
+// hooks.  Hook output goes to the job's pre_run_hook and post_run_hook streams,
+// or to stderr for jobs without them, and never to the job log.
+func (er *execRunner) runWithHooks(ctx context.Context, log logrus.FieldLogger, job Job) error {
+	payload, err := writeHookPayload(job)
+	if err != nil {
+		log.WithError(err).Error("failed to write job payload for hooks")
+		er.status(ctx, job, ReceivedState, ErroredState)
+		return errors.Wrap(err, "failed to write job payload for hooks")
+	}
+
+	defer os.Remove(payload)
+
+	env := append(append(er.baseEnviron(), travisEnviron(job)...),
+		fmt.Sprintf("TRAVIS_JOB_PAYLOAD=%s", payload))
+
+	if er.cfg.PreRunHook != "" {
+		log.Debug("running pre-run hook")
+		err = er.runHook(ctx, log, job, preRunHookStreamName, er.cfg.PreRunHook, env)
+		if err != nil {
+			log.WithError(err).Error("pre-run hook failed")
+			er.status(ctx, job, ReceivedState, ErroredState)
+			return errors.Wrap(err, "pre-run hook failed")
+		}
+	}
+
+	recorder := &stateRecordingStatuser{Statuser: er.statuser, state: ReceivedState}
+	jobRunner := *er
+	jobRunner.statuser = recorder
+
+	err = jobRunner.run(ctx, log, job)
+
+	if er.cfg.PostRunHook != "" {
+		hookCtx := ctx
+		if ctx.Err() != nil {
+			var cancel context.CancelFunc
+			hookCtx, cancel = context.WithTimeout(context.Background(), postRunHookTimeout)
+			defer cancel()
+		}
+
+		log.WithField("state", recorder.State()).Debug("running post-run hook")
+		hookErr := er.runHook(hookCtx, log, job, postRunHookStreamName, er.cfg.PostRunHook,
+			append(env, fmt.Sprintf("TRAVIS_JOB_STATE=%s", recorder.State())))
+		if hookErr != nil {
+			log.WithError(hookErr).Error("post-run hook failed")
+		}
+	}
+
+	return err
+}
+
+func (er *execRunner) runHook(ctx context.Context, log logrus.FieldLogger, job Job, streamName, command string, env []string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	str, ok := job.Streams()[streamName]
+	if !ok {
+		str = NewNamedStream(streamName)
+		str.SetDest(os.Stderr)
+	}
+
+	pw := er.startStream(ctx, log, job, str)
+	defer pw.Close()
+
+	return er.runShellCommand(ctx, log.WithField("hook", streamName), command, "", env, pw)
+}
+
+// writeHookPayload writes the job's payload to a private temporary file for
+// hooks to read, returning its path.
+func writeHookPayload(job Job) (string, error) {
+	b, err := json.Marshal(job.Raw())
+	if err != nil {
+		return "", err
+	}
+
+	f, err := ioutil.TempFile("", fmt.Sprintf("travis-job-%s-payload-", job.ID()))
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+
+	_, err = f.Write(b)
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), f.Close()
+}
+
+// stateRecordingStatuser passes state updates on to a Statuser, remembering
+// the latest state.
+type stateRecordingStatuser struct {
+	Statuser
+
+	mu    sync.Mutex
+	state State
+}
+
+func (srs *stateRecordingStatuser) Status(ctx context.Context, job Job, stateUpdate StateUpdate) error {
+	srs.mu.Lock()
+	srs.state = stateUpdate.New()
+	srs.mu.Unlock()
+
+	return srs.Statuser.Status(ctx, job, stateUpdate)
+}
+
+func (srs *stateRecordingStatuser) State() State {
+	srs.mu.Lock()
+	defer srs.mu.Unlock()
+
+	return srs.state
+}
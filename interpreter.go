@@ -0,0 +1,180 @@
+package job
+
+import (
+	"bufio"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultInterpreterName = "bash"
+)
+
+// Interpreter is a command that runs job scripts.  The script path is
+// appended to Command, and Extensions are used both to pick the interpreter
+// from a script name and to name the script file written for it.
+type Interpreter struct {
+	Name       string
+	Command    []string
+	Extensions []string
+}
+
+// ParseInterpreter parses an interpreter in the form
+// "<name>[:<ext>...]=<command> [<arg>...]", e.g. "ruby:rb:rake=ruby -w".
+// Extensions are separated by colons rather than commas so that several
+// interpreters can be given in one comma-separated list.
+func ParseInterpreter(s string) (*Interpreter, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid interpreter %q", s)
+	}
+
+	command := strings.Fields(parts[1])
+	if len(command) == 0 {
+		return nil, fmt.Errorf("missing command for interpreter %q", s)
+	}
+
+	nameParts := strings.Split(parts[0], ":")
+	interp := &Interpreter{
+		Name:       strings.TrimSpace(nameParts[0]),
+		Command:    command,
+		Extensions: []string{},
+	}
+
+	if interp.Name == "" {
+		return nil, fmt.Errorf("missing name for interpreter %q", s)
+	}
+
+	for _, ext := range nameParts[1:] {
+		ext = strings.TrimPrefix(strings.TrimSpace(ext), ".")
+		if ext != "" {
+			interp.Extensions = append(interp.Extensions, ext)
+		}
+	}
+
+	return interp, nil
+}
+
+func (interp *Interpreter) extension() string {
+	if len(interp.Extensions) > 0 {
+		return interp.Extensions[0]
+	}
+
+	return interp.Name
+}
+
+func (interp *Interpreter) commandFor(scriptPath string) (string, []string) {
+	return interp.Command[0], append(append([]string{}, interp.Command[1:]...), scriptPath)
+}
+
+// InterpreterRegistry holds the interpreters a Runner may select for a job
+// script.
+type InterpreterRegistry struct {
+	mu           sync.RWMutex
+	interpreters map[string]*Interpreter
+}
+
+// NewInterpreterRegistry builds a registry containing bash, sh and python.
+func NewInterpreterRegistry() *InterpreterRegistry {
+	ir := &InterpreterRegistry{interpreters: map[string]*Interpreter{}}
+	ir.Register(&Interpreter{Name: "bash", Command: []string{"bash"}, Extensions: []string{"bash"}})
+	ir.Register(&Interpreter{Name: "sh", Command: []string{"sh"}, Extensions: []string{"sh"}})
+	ir.Register(&Interpreter{Name: "python", Command: []string{"python3"}, Extensions: []string{"py"}})
+	return ir
+}
+
+// Register adds an interpreter, replacing any existing one of the same name.
+func (ir *InterpreterRegistry) Register(interp *Interpreter) {
+	ir.mu.Lock()
+	defer ir.mu.Unlock()
+
+	ir.interpreters[interp.Name] = interp
+}
+
+func (ir *InterpreterRegistry) Lookup(name string) (*Interpreter, bool) {
+	ir.mu.RLock()
+	defer ir.mu.RUnlock()
+
+	interp, ok := ir.interpreters[name]
+	return interp, ok
+}
+
+func (ir *InterpreterRegistry) lookupExtension(ext string) (*Interpreter, bool) {
+	ir.mu.RLock()
+	defer ir.mu.RUnlock()
+
+	for _, interp := range ir.interpreters {
+		for _, interpExt := range interp.Extensions {
+			if interpExt == ext {
+				return interp, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// Select picks the registered interpreter for a job script, preferring the
+// job's explicit interpreter, then the script's shebang line, then the
+// extension of the script name, and finally bash.  An explicit interpreter or
+// shebang naming an unregistered interpreter is an error.
+func (ir *InterpreterRegistry) Select(job Job, script string) (*Interpreter, error) {
+	if name := job.ScriptInterpreter(); name != "" {
+		interp, ok := ir.Lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown job script interpreter %q", name)
+		}
+		return interp, nil
+	}
+
+	if shebang := scriptShebang(script); shebang != "" {
+		for _, name := range []string{shebang, strings.TrimRight(shebang, "0123456789.")} {
+			if interp, ok := ir.Lookup(name); ok {
+				return interp, nil
+			}
+		}
+
+		return nil, fmt.Errorf("unknown job script interpreter %q in shebang", shebang)
+	}
+
+	if ext := strings.TrimPrefix(path.Ext(job.ScriptName()), "."); ext != "" {
+		if interp, ok := ir.lookupExtension(ext); ok {
+			return interp, nil
+		}
+	}
+
+	interp, ok := ir.Lookup(defaultInterpreterName)
+	if !ok {
+		return nil, fmt.Errorf("no default job script interpreter %q", defaultInterpreterName)
+	}
+
+	return interp, nil
+}
+
+// scriptShebang returns the base name of the program named by a script's
+// shebang line, looking through "/usr/bin/env".
+func scriptShebang(script string) string {
+	line, _ := bufio.NewReader(strings.NewReader(script)).ReadString('\n')
+	if !strings.HasPrefix(line, "#!") {
+		return ""
+	}
+
+	fields := strings.Fields(strings.TrimPrefix(line, "#!"))
+	if len(fields) == 0 {
+		return ""
+	}
+
+	program := path.Base(fields[0])
+	if program == "env" {
+		for _, field := range fields[1:] {
+			if !strings.HasPrefix(field, "-") && !strings.Contains(field, "=") {
+				return path.Base(field)
+			}
+		}
+		return ""
+	}
+
+	return program
+}
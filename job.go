@@ -2,10 +2,14 @@ package job
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"sync"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
 type Job interface {
@@ -13,9 +17,15 @@ type Job interface {
 	JWT() string
 	JobStateURL() string
 	LogPartsURL() string
+	ArtifactsURL() string
 	Raw() interface{}
-	Script() (string, error)
+	Metadata() *Metadata
+	Script(context.Context) (string, error)
+	ScriptSignature() (*ScriptSignature, error)
+	ScriptName() string
+	ScriptInterpreter() string
 	Streams() map[string]Stream
+	EnvVars() []EnvVar
 }
 
 func newJobFromBytes(b []byte) (Job, error) {
@@ -24,52 +34,179 @@ func newJobFromBytes(b []byte) (Job, error) {
 	return j, err
 }
 
+// validateJob checks that a job carries everything needed to run it and report
+// on it, including a decodable script.
+func validateJob(ctx context.Context, j Job) error {
+	if j.ID() == "" {
+		return fmt.Errorf("missing job ID")
+	}
+
+	if j.JobStateURL() == "" {
+		return fmt.Errorf("missing job state URL")
+	}
+
+	_, err := j.Script(ctx)
+	if err != nil {
+		return errors.Wrap(err, "invalid job script")
+	}
+
+	return nil
+}
+
 type job struct {
-	Data        *jobData      `json:"data"`
-	JobScript   *jobJobScript `json:"job_script"`
-	JobStateURL string        `json:"job_state_url"`
-	LogPartsURL string        `json:"log_parts_url"`
-	JWT         string        `json:"jwt"`
-	ImageName   string        `json:"image_name"`
+	Data         *jobData      `json:"data"`
+	JobScript    *jobJobScript `json:"job_script"`
+	JobStateURL  string        `json:"job_state_url"`
+	LogPartsURL  string        `json:"log_parts_url"`
+	ArtifactsURL string        `json:"artifacts_url"`
+	JWT          string        `json:"jwt"`
+	ImageName    string        `json:"image_name"`
 }
 
 type jobData struct {
-	Type       string                 `json:"type"`
-	Job        *jobDataJob            `json:"job"`
-	Build      *jobDataBuild          `json:"source"`
-	Repository *jobDataRepository     `json:"repository"`
-	UUID       string                 `json:"uuid"`
-	Config     map[string]interface{} `json:"config"`
-	Timeouts   *jobDataTimeouts       `json:"timeouts,omitempty"`
-	VMType     string                 `json:"vm_type"`
-	VMConfig   *jobDataVMConfig       `json:"vm_config"`
-	Meta       *jobDataMeta           `json:"meta"`
-	Queue      string                 `json:"queue"`
-	Trace      bool                   `json:"trace"`
-	Warmer     bool                   `json:"warmer"`
-	Streams    map[string]Stream      `json:"streams"`
+	Type        string                 `json:"type"`
+	Job         *jobDataJob            `json:"job"`
+	Build       *jobDataBuild          `json:"source"`
+	Repository  *jobDataRepository     `json:"repository"`
+	UUID        string                 `json:"uuid"`
+	Config      map[string]interface{} `json:"config"`
+	Timeouts    *jobDataTimeouts       `json:"timeouts,omitempty"`
+	VMType      string                 `json:"vm_type"`
+	VMConfig    *jobDataVMConfig       `json:"vm_config"`
+	Meta        *jobDataMeta           `json:"meta"`
+	Queue       string                 `json:"queue"`
+	Trace       bool                   `json:"trace"`
+	Warmer      bool                   `json:"warmer"`
+	Streams     map[string]Stream      `json:"streams"`
+	EnvVars     []*jobDataEnvVar       `json:"env_vars"`
+	SSHKey      *jobDataSSHKey         `json:"ssh_key"`
+	Enterprise  bool                   `json:"enterprise"`
+	PreferHTTPS bool                   `json:"prefer_https"`
+
+	CacheSettings *jobDataCacheSettings `json:"cache_settings,omitempty"`
 }
 
 type jobDataJob struct {
-	ID       uint64     `json:"id"`
-	Number   string     `json:"number"`
-	QueuedAt *time.Time `json:"queued_at"`
+	ID               uint64                 `json:"id"`
+	Number           string                 `json:"number"`
+	QueuedAt         *time.Time             `json:"queued_at"`
+	Commit           string                 `json:"commit"`
+	CommitRange      string                 `json:"commit_range"`
+	CommitMessage    string                 `json:"commit_message"`
+	Branch           string                 `json:"branch"`
+	Ref              *string                `json:"ref"`
+	Tag              *string                `json:"tag"`
+	PullRequest      jobDataPullRequest     `json:"pull_request"`
+	State            string                 `json:"state"`
+	SecureEnvEnabled bool                   `json:"secure_env_enabled"`
+	SecureEnvRemoved bool                   `json:"secure_env_removed"`
+	DebugOptions     map[string]interface{} `json:"debug_options"`
+	AllowFailure     bool                   `json:"allow_failure"`
+	StageName        *string                `json:"stage_name"`
+}
+
+// jobDataPullRequest is the pull request number, which is sent as false for
+// jobs that are not pull request builds.
+type jobDataPullRequest uint64
+
+func (pr *jobDataPullRequest) UnmarshalJSON(b []byte) error {
+	switch string(b) {
+	case "false", "null":
+		*pr = 0
+		return nil
+	}
+
+	var number uint64
+	err := json.Unmarshal(b, &number)
+	if err != nil {
+		return errors.Wrap(err, "failed to decode pull request number")
+	}
+
+	*pr = jobDataPullRequest(number)
+	return nil
+}
+
+func (pr jobDataPullRequest) MarshalJSON() ([]byte, error) {
+	if pr == 0 {
+		return []byte("false"), nil
+	}
+
+	return json.Marshal(uint64(pr))
 }
 
 type jobJobScript struct {
-	Name     string `json:"name"`
-	Encoding string `json:"encoding"`
-	Content  string `json:"content"`
+	Name        string `json:"name"`
+	Encoding    string `json:"encoding"`
+	Content     string `json:"content"`
+	URL         string `json:"url,omitempty"`
+	SHA256      string `json:"sha256,omitempty"`
+	Interpreter string `json:"interpreter,omitempty"`
+
+	Signature *jobJobScriptSignature `json:"signature,omitempty"`
+}
+
+type jobJobScriptSignature struct {
+	Algorithm string `json:"algorithm"`
+	KeyID     string `json:"key_id"`
+	Value     string `json:"value"`
+}
+
+type jobDataEnvVar struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Public bool   `json:"public"`
+	Secure bool   `json:"secure"`
+}
+
+type jobDataSSHKey struct {
+	Value   string `json:"value"`
+	Source  string `json:"source"`
+	Encoded bool   `json:"encoded"`
+}
+
+type jobDataCacheSettings struct {
+	Type         string                    `json:"type"`
+	FetchTimeout uint64                    `json:"fetch_timeout"`
+	PushTimeout  uint64                    `json:"push_timeout"`
+	S3           *jobDataCacheSettingsS3   `json:"s3,omitempty"`
+	File         *jobDataCacheSettingsFile `json:"file,omitempty"`
+}
+
+type jobDataCacheSettingsS3 struct {
+	AccessKeyID         string `json:"access_key_id"`
+	AWSSignatureVersion string `json:"aws_signature_version"`
+	Bucket              string `json:"bucket"`
+	Hostname            string `json:"hostname"`
+	Region              string `json:"region"`
+	SecretAccessKey     string `json:"secret_access_key"`
+}
+
+type jobDataCacheSettingsFile struct {
+	URL string `json:"url"`
 }
 
 type jobDataBuild struct {
-	ID     uint64 `json:"id"`
-	Number string `json:"number"`
+	ID        uint64 `json:"id"`
+	Number    string `json:"number"`
+	EventType string `json:"event_type"`
 }
 
 type jobDataRepository struct {
-	ID   uint64 `json:"id"`
-	Slug string `json:"slug"`
+	ID                  uint64     `json:"id"`
+	GithubID            uint64     `json:"github_id"`
+	Private             bool       `json:"private"`
+	Slug                string     `json:"slug"`
+	SourceURL           string     `json:"source_url"`
+	SourceHost          string     `json:"source_host"`
+	APIURL              string     `json:"api_url"`
+	LastBuildID         uint64     `json:"last_build_id"`
+	LastBuildNumber     string     `json:"last_build_number"`
+	LastBuildStartedAt  *time.Time `json:"last_build_started_at"`
+	LastBuildFinishedAt *time.Time `json:"last_build_finished_at"`
+	LastBuildDuration   uint64     `json:"last_build_duration"`
+	LastBuildState      string     `json:"last_build_state"`
+	DefaultBranch       string     `json:"default_branch"`
+	Description         string     `json:"description"`
 }
 
 type jobDataTimeouts struct {
@@ -89,6 +226,9 @@ type jobDataVMConfig struct {
 
 type jobWrapper struct {
 	J *job
+
+	scriptMu sync.Mutex
+	script   *string
 }
 
 func (j *jobWrapper) data() *jobData {
@@ -123,6 +263,14 @@ func (j *jobWrapper) LogPartsURL() string {
 	return ""
 }
 
+func (j *jobWrapper) ArtifactsURL() string {
+	if j.J != nil {
+		return j.J.ArtifactsURL
+	}
+
+	return ""
+}
+
 func (j *jobWrapper) JWT() string {
 	if j.J != nil {
 		return j.J.JWT
@@ -139,22 +287,98 @@ func (j *jobWrapper) Raw() interface{} {
 	return nil
 }
 
-func (j *jobWrapper) Script() (string, error) {
+// Script returns the job's decoded script, fetching it first if it is
+// referenced by URL.  Once a script has been fetched and decoded it is reused
+// by later calls.
+func (j *jobWrapper) Script(ctx context.Context) (string, error) {
+	j.scriptMu.Lock()
+	defer j.scriptMu.Unlock()
+
+	if j.script != nil {
+		return *j.script, nil
+	}
+
+	script, err := j.loadScript(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	j.script = &script
+	return script, nil
+}
+
+func (j *jobWrapper) loadScript(ctx context.Context) (string, error) {
 	if j.J == nil || j.J.JobScript == nil {
 		return "", nil
 	}
 
 	script := j.J.JobScript
-	if script.Content == "" {
+	content := []byte(script.Content)
+	encoding := script.Encoding
+
+	if script.URL != "" {
+		fetched, err := fetchScript(ctx, script.URL)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to fetch job script")
+		}
+
+		content = fetched
+		if encoding == "" {
+			encoding = scriptEncodingPlain
+		}
+	}
+
+	if len(content) == 0 {
 		return "", nil
 	}
 
-	if script.Encoding != "base64" {
-		return "", fmt.Errorf("unknown job script encoding: %s", script.Encoding)
+	decoded, err := decodeScript(content, encoding)
+	if err != nil {
+		return "", err
+	}
+
+	if script.SHA256 != "" {
+		err = verifyScriptSHA256(decoded, script.SHA256)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return string(decoded), nil
+}
+
+func (j *jobWrapper) ScriptName() string {
+	if j.J == nil || j.J.JobScript == nil {
+		return ""
+	}
+
+	return j.J.JobScript.Name
+}
+
+func (j *jobWrapper) ScriptInterpreter() string {
+	if j.J == nil || j.J.JobScript == nil {
+		return ""
 	}
 
-	decoded, err := base64.StdEncoding.DecodeString(script.Content)
-	return string(decoded), err
+	return j.J.JobScript.Interpreter
+}
+
+func (j *jobWrapper) ScriptSignature() (*ScriptSignature, error) {
+	if j.J == nil || j.J.JobScript == nil || j.J.JobScript.Signature == nil {
+		return nil, nil
+	}
+
+	sig := j.J.JobScript.Signature
+	value, err := base64.StdEncoding.DecodeString(sig.Value)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode job script signature")
+	}
+
+	return &ScriptSignature{
+		Algorithm: sig.Algorithm,
+		KeyID:     sig.KeyID,
+		Value:     value,
+	}, nil
 }
 
 func (j *jobWrapper) Streams() map[string]Stream {
@@ -171,6 +395,29 @@ func (j *jobWrapper) Streams() map[string]Stream {
 	return streams
 }
 
+func (j *jobWrapper) EnvVars() []EnvVar {
+	envVars := []EnvVar{}
+	data := j.data()
+	if data == nil {
+		return envVars
+	}
+
+	for _, ev := range data.EnvVars {
+		if ev == nil || ev.Name == "" {
+			continue
+		}
+
+		envVars = append(envVars, EnvVar{
+			Name:   ev.Name,
+			Value:  ev.Value,
+			Public: ev.Public,
+			Secure: ev.Secure,
+		})
+	}
+
+	return envVars
+}
+
 func (j *jobWrapper) MarshalJSON() ([]byte, error) {
 	outJSON := &bytes.Buffer{}
 	err := json.NewEncoder(outJSON).Encode(j.Raw())
@@ -0,0 +1,288 @@
+package job
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCgroupParent = "/sys/fs/cgroup/travis-job"
+
+	limitSampleInterval = 100 * time.Millisecond
+
+	// the CPU time reaped from a process killed by RLIMIT_CPU can add up
+	// to a few ticks less than the limit
+	cpuAccountingSlack = 50 * time.Millisecond
+)
+
+// ResourceLimits are applied to every job process.  Zero values leave the
+// corresponding resource unlimited.  NoFile, NProc, Core and CPU are applied
+// as rlimits, while MemoryMax, CPUs, PidsMax and NProc require cgroup v2 and
+// a cgroup under CgroupParent that this process may manage.  A job whose
+// cgroup can't be created is errored rather than run without its limits.
+//
+// A job that breaches a limit, in any of its processes, is reported as
+// errored with the reason:
+//
+//   - CPU, once the job's processes have used that much CPU time between
+//     them, which is the case as soon as any one of them is stopped by its
+//     rlimit
+//   - MemoryMax, once the cgroup has had a process killed for running out of
+//     memory
+//   - PidsMax and NProc, which is also applied to the cgroup, once the
+//     cgroup has refused a new process
+//   - NoFile, once a process has been seen with as many files open as it
+//     allows, by sampling every limitSampleInterval on linux
+//   - Core, once a core file in the workspace has been cut short by it
+//
+// CPUs throttles job processes rather than stopping them, so it can't be
+// breached.
+type ResourceLimits struct {
+	NoFile uint64
+	NProc  uint64
+	Core   uint64
+	CPU    time.Duration
+
+	CgroupParent string
+	MemoryMax    uint64
+	CPUs         float64
+	PidsMax      uint64
+}
+
+func (rl *ResourceLimits) hasRlimits() bool {
+	return rl != nil && (rl.NoFile > 0 || rl.NProc > 0 || rl.Core > 0 || rl.CPU > 0)
+}
+
+func (rl *ResourceLimits) hasCgroupLimits() bool {
+	return rl != nil && (rl.MemoryMax > 0 || rl.CPUs > 0 || rl.PidsMax > 0 || rl.NProc > 0)
+}
+
+// wrapCommand runs the command through bash's ulimit builtin so that the
+// rlimits are in place before the command is executed.
+func (rl *ResourceLimits) wrapCommand(name string, args []string) (string, []string) {
+	if !rl.hasRlimits() {
+		return name, args
+	}
+
+	ulimits := []string{}
+	if rl.NoFile > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -n %d", rl.NoFile))
+	}
+	if rl.NProc > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -u %d", rl.NProc))
+	}
+	if rl.Core > 0 {
+		// bash counts core file sizes in 1024-byte blocks
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -c %d", (rl.Core+1023)/1024))
+	}
+	if rl.CPU > 0 {
+		// the soft limit sends SIGXCPU, which is what identifies the breach,
+		// and the hard limit follows up with SIGKILL
+		seconds := int64((rl.CPU + time.Second - 1) / time.Second)
+		ulimits = append(ulimits,
+			fmt.Sprintf("ulimit -S -t %d", seconds),
+			fmt.Sprintf("ulimit -H -t %d", seconds+1))
+	}
+
+	script := strings.Join(append(ulimits, `exec "$@"`), " && ")
+	return "bash", append([]string{"-c", script, "travis-job-limits", name}, args...)
+}
+
+// breach describes the limit a finished job ran into, if any.  ps is the
+// state of the job process, cg and ls may be nil, and dir is the workspace.
+func (rl *ResourceLimits) breach(ps *os.ProcessState, cg *jobCgroup, ls *limitSampler, dir string) string {
+	if rl == nil {
+		return ""
+	}
+
+	if cg != nil {
+		breach := cg.breach()
+		if breach != "" {
+			return breach
+		}
+	}
+
+	// the job process is killed by SIGXCPU before the accounted CPU time
+	// necessarily adds up to the limit
+	if rl.CPU > 0 && (processSignalName(ps) == "SIGXCPU" || rl.cpuTimeUsed(ps, cg) >= rl.CPU-cpuAccountingSlack) {
+		return "cpu time limit exceeded"
+	}
+
+	breach := ls.stop()
+	if breach != "" {
+		return breach
+	}
+
+	if rl.Core > 0 && rl.coreFileCut(dir) {
+		return "core file size limit exceeded"
+	}
+
+	return ""
+}
+
+// cpuTimeUsed is the CPU time used by all of the job's processes: the
+// cgroup's own count if there is one, or else the job process's, which
+// includes that of every child it has waited for.
+func (rl *ResourceLimits) cpuTimeUsed(ps *os.ProcessState, cg *jobCgroup) time.Duration {
+	if cg != nil {
+		used, ok := cg.cpuTimeUsed()
+		if ok {
+			return used
+		}
+	}
+
+	if ps == nil {
+		return 0
+	}
+
+	return ps.UserTime() + ps.SystemTime()
+}
+
+// coreFileCut reports whether a core file under dir, where the kernel writes
+// them unless core_pattern says otherwise, has been cut short by Core.  The
+// kernel stops writing a core file before the page that would take it past
+// the limit, so a cut file ends within a page of it.
+func (rl *ResourceLimits) coreFileCut(dir string) bool {
+	// bash sets the limit in 1024-byte blocks, see wrapCommand
+	limit := int64((rl.Core + 1023) / 1024 * 1024)
+
+	cut := false
+	_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+
+		name := info.Name()
+		if name != "core" && !strings.HasPrefix(name, "core.") {
+			return nil
+		}
+
+		if info.Size() > limit-int64(os.Getpagesize()) {
+			cut = true
+			return filepath.SkipDir
+		}
+
+		return nil
+	})
+
+	return cut
+}
+
+// cgroupLimits returns the cgroup v2 interface files and values for the
+// configured limits.
+func (rl *ResourceLimits) cgroupLimits() map[string]string {
+	limits := map[string]string{}
+	if rl.MemoryMax > 0 {
+		limits["memory.max"] = fmt.Sprintf("%d", rl.MemoryMax)
+		limits["memory.swap.max"] = "0"
+	}
+	if rl.CPUs > 0 {
+		period := uint64(100000)
+		limits["cpu.max"] = fmt.Sprintf("%d %d", uint64(rl.CPUs*float64(period)), period)
+	}
+	if rl.PidsMax > 0 || rl.NProc > 0 {
+		pidsMax := rl.PidsMax
+		if pidsMax == 0 || (rl.NProc > 0 && rl.NProc < pidsMax) {
+			pidsMax = rl.NProc
+		}
+		limits["pids.max"] = fmt.Sprintf("%d", pidsMax)
+	}
+	return limits
+}
+
+// limitSampler samples a running job's processes for a NoFile breach, which
+// nothing else reports, since running into it only makes the process's own
+// system calls fail.  It may miss a process that only briefly has all of its
+// files open, between samples.
+type limitSampler struct {
+	rl *ResourceLimits
+	cg *jobCgroup
+
+	mu      sync.Mutex
+	started bool
+	breach  string
+	stopped chan struct{}
+	done    chan struct{}
+}
+
+// newLimitSampler returns nil if there is nothing to sample.
+func newLimitSampler(rl *ResourceLimits, cg *jobCgroup) (*limitSampler, error) {
+	if rl == nil || rl.NoFile == 0 {
+		return nil, nil
+	}
+
+	err := checkProcessSampling()
+	if err != nil {
+		return nil, err
+	}
+
+	return &limitSampler{
+		rl:      rl,
+		cg:      cg,
+		stopped: make(chan struct{}),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// start samples the processes in the job's cgroup or, without one, in the
+// process group pgid until stop is called.
+func (ls *limitSampler) start(pgid int) {
+	if ls == nil {
+		return
+	}
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.started = true
+
+	go func() {
+		defer close(ls.done)
+
+		ticker := time.NewTicker(limitSampleInterval)
+		defer ticker.Stop()
+
+		for {
+			if maxOpenFiles(ls.cg, pgid) >= ls.rl.NoFile {
+				ls.mu.Lock()
+				ls.breach = "open file limit reached"
+				ls.mu.Unlock()
+				return
+			}
+
+			select {
+			case <-ls.stopped:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// stop stops sampling and returns the breach seen, if any.  It may be called
+// more than once, and whether or not start was.
+func (ls *limitSampler) stop() string {
+	if ls == nil {
+		return ""
+	}
+
+	ls.mu.Lock()
+	started := ls.started
+	select {
+	case <-ls.stopped:
+	default:
+		close(ls.stopped)
+	}
+	ls.mu.Unlock()
+
+	if started {
+		<-ls.done
+	}
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.breach
+}
@@ -0,0 +1,212 @@
+package job
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// jobCgroup is a cgroup v2 group created for a single job, into which the job
+// process is started.
+type jobCgroup struct {
+	path string
+	dir  *os.File
+}
+
+func newJobCgroup(rl *ResourceLimits, jobID string) (*jobCgroup, error) {
+	if !rl.hasCgroupLimits() {
+		return nil, nil
+	}
+
+	parent := rl.CgroupParent
+	if parent == "" {
+		parent = defaultCgroupParent
+	}
+
+	_, err := os.Stat(filepath.Join(filepath.Dir(parent), "cgroup.controllers"))
+	if err != nil {
+		return nil, errors.Wrap(err, "cgroup v2 is not available")
+	}
+
+	err = os.MkdirAll(parent, os.FileMode(0755))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create parent cgroup")
+	}
+
+	// Enabling controllers fails for those that are already enabled or not
+	// delegated, and missing ones are reported when the limits are written.
+	for _, controller := range []string{"cpu", "memory", "pids"} {
+		_ = ioutil.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte("+"+controller), os.FileMode(0644))
+	}
+
+	cg := &jobCgroup{path: filepath.Join(parent, fmt.Sprintf("travis-job-%s", jobID))}
+	err = os.Mkdir(cg.path, os.FileMode(0755))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create job cgroup")
+	}
+
+	for name, value := range rl.cgroupLimits() {
+		err = ioutil.WriteFile(filepath.Join(cg.path, name), []byte(value), os.FileMode(0644))
+		if err != nil && name != "memory.swap.max" {
+			_ = cg.remove()
+			return nil, errors.Wrapf(err, "failed to set cgroup limit %s", name)
+		}
+	}
+
+	cg.dir, err = os.Open(cg.path)
+	if err != nil {
+		_ = cg.remove()
+		return nil, errors.Wrap(err, "failed to open job cgroup")
+	}
+
+	return cg, nil
+}
+
+// apply makes the command start directly inside the cgroup.
+func (cg *jobCgroup) apply(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(cg.dir.Fd())
+}
+
+// breach describes the limit the job ran into, if any.
+func (cg *jobCgroup) breach() string {
+	if cg.eventCount("memory.events", "oom_kill") > 0 {
+		return "memory limit exceeded"
+	}
+
+	if cg.eventCount("pids.events", "max") > 0 {
+		return "process limit reached"
+	}
+
+	return ""
+}
+
+// cpuTimeUsed is the CPU time used by every process that has been in the
+// cgroup.
+func (cg *jobCgroup) cpuTimeUsed() (time.Duration, bool) {
+	usec, ok := cg.stat("cpu.stat", "usage_usec")
+	return time.Duration(usec) * time.Microsecond, ok
+}
+
+func (cg *jobCgroup) eventCount(file, event string) uint64 {
+	count, _ := cg.stat(file, event)
+	return count
+}
+
+// stat reads a value from one of the cgroup's flat keyed files.
+func (cg *jobCgroup) stat(file, key string) (uint64, bool) {
+	f, err := os.Open(filepath.Join(cg.path, file))
+	if err != nil {
+		return 0, false
+	}
+
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == key {
+			value, err := strconv.ParseUint(fields[1], 10, 64)
+			return value, err == nil
+		}
+	}
+
+	return 0, false
+}
+
+// procs returns the IDs of the processes in the cgroup.
+func (cg *jobCgroup) procs() []int {
+	b, err := ioutil.ReadFile(filepath.Join(cg.path, "cgroup.procs"))
+	if err != nil {
+		return nil
+	}
+
+	pids := []int{}
+	for _, field := range strings.Fields(string(b)) {
+		pid, err := strconv.Atoi(field)
+		if err == nil {
+			pids = append(pids, pid)
+		}
+	}
+
+	return pids
+}
+
+func checkProcessSampling() error {
+	_, err := os.Stat("/proc/self/fd")
+	return errors.Wrap(err, "processes can't be sampled without /proc")
+}
+
+// maxOpenFiles returns the most files any one process in the cgroup has
+// open, or without a cgroup any one process in the process group pgid.
+func maxOpenFiles(cg *jobCgroup, pgid int) uint64 {
+	var pids []int
+	if cg != nil {
+		pids = cg.procs()
+	} else {
+		pids = processGroupPids(pgid)
+	}
+
+	max := uint64(0)
+	for _, pid := range pids {
+		fds, err := ioutil.ReadDir(fmt.Sprintf("/proc/%d/fd", pid))
+		if err == nil && uint64(len(fds)) > max {
+			max = uint64(len(fds))
+		}
+	}
+
+	return max
+}
+
+// processGroupPids returns the IDs of the processes in the process group
+// pgid, as seen in /proc.
+func processGroupPids(pgid int) []int {
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+
+	pids := []int{}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		if err != nil {
+			continue
+		}
+
+		// the command name is in parentheses and may contain anything, so
+		// the fields are counted from the last closing one: state, ppid,
+		// pgrp
+		stat := string(b)
+		fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+		if len(fields) > 2 && fields[2] == strconv.Itoa(pgid) {
+			pids = append(pids, pid)
+		}
+	}
+
+	return pids
+}
+
+func (cg *jobCgroup) remove() error {
+	if cg.dir != nil {
+		cg.dir.Close()
+	}
+
+	return os.Remove(cg.path)
+}
@@ -0,0 +1,42 @@
+//go:build !linux
+// +build !linux
+
+package job
+
+import (
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+type jobCgroup struct{}
+
+func newJobCgroup(rl *ResourceLimits, jobID string) (*jobCgroup, error) {
+	if !rl.hasCgroupLimits() {
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("cgroup limits are only available on linux")
+}
+
+func (cg *jobCgroup) apply(cmd *exec.Cmd) {}
+
+func (cg *jobCgroup) breach() string {
+	return ""
+}
+
+func (cg *jobCgroup) cpuTimeUsed() (time.Duration, bool) {
+	return 0, false
+}
+
+func (cg *jobCgroup) remove() error {
+	return nil
+}
+
+func checkProcessSampling() error {
+	return fmt.Errorf("open file limit breaches can only be detected on linux")
+}
+
+func maxOpenFiles(cg *jobCgroup, pgid int) uint64 {
+	return 0
+}
@@ -0,0 +1,67 @@
+package job
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestResourceLimitsCgroupLimits(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		limits   *ResourceLimits
+		expected map[string]string
+	}{
+		{"none", &ResourceLimits{}, map[string]string{}},
+		{"pids max", &ResourceLimits{PidsMax: 100}, map[string]string{"pids.max": "100"}},
+		{"nproc", &ResourceLimits{NProc: 50}, map[string]string{"pids.max": "50"}},
+		{"nproc below pids max", &ResourceLimits{NProc: 50, PidsMax: 100}, map[string]string{"pids.max": "50"}},
+		{"pids max below nproc", &ResourceLimits{NProc: 500, PidsMax: 100}, map[string]string{"pids.max": "100"}},
+		{"nofile and core", &ResourceLimits{NoFile: 64, Core: 1024}, map[string]string{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := tc.limits.cgroupLimits()
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestResourceLimitsCoreFileCut(t *testing.T) {
+	limit := uint64(64 * 1024)
+
+	for _, tc := range []struct {
+		name     string
+		file     string
+		size     int
+		expected bool
+	}{
+		{"no core file", "", 0, false},
+		{"small core file", "core", 1024, false},
+		{"core file at the limit", "core", int(limit), true},
+		{"core file with pid at the limit", "core.1234", int(limit), true},
+		{"nested core file at the limit", "src/core", int(limit), true},
+		{"other file at the limit", "corefile.txt", int(limit), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tc.file != "" {
+				path := filepath.Join(dir, tc.file)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(path, make([]byte, tc.size), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			actual := (&ResourceLimits{Core: limit}).coreFileCut(dir)
+			if actual != tc.expected {
+				t.Fatalf("expected %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
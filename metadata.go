@@ -0,0 +1,270 @@
+package job
+
+import (
+	"time"
+)
+
+// Metadata is a typed view of everything a job payload carries beyond its
+// script and URLs.  Every call builds a fresh copy, so changing it does not
+// change the job.
+type Metadata struct {
+	Type        string
+	UUID        string
+	ImageName   string
+	Queue       string
+	VMType      string
+	VMConfig    VMConfigMetadata
+	Trace       bool
+	Warmer      bool
+	Enterprise  bool
+	PreferHTTPS bool
+	Config      map[string]interface{}
+	Job         JobMetadata
+	Build       BuildMetadata
+	Repository  RepositoryMetadata
+	Timeouts    TimeoutsMetadata
+
+	// CacheSettings is nil for jobs without build cache settings.
+	CacheSettings *CacheSettings
+}
+
+type JobMetadata struct {
+	ID               uint64
+	Number           string
+	QueuedAt         *time.Time
+	Commit           string
+	CommitRange      string
+	CommitMessage    string
+	Branch           string
+	Ref              string
+	Tag              string
+	PullRequest      uint64
+	State            string
+	SecureEnvEnabled bool
+	SecureEnvRemoved bool
+	DebugOptions     map[string]interface{}
+	AllowFailure     bool
+	StageName        string
+}
+
+// IsPullRequest reports whether the job is a pull request build.
+func (jm JobMetadata) IsPullRequest() bool {
+	return jm.PullRequest != 0
+}
+
+type BuildMetadata struct {
+	ID        uint64
+	Number    string
+	EventType string
+}
+
+type RepositoryMetadata struct {
+	ID                  uint64
+	GithubID            uint64
+	Private             bool
+	Slug                string
+	SourceURL           string
+	SourceHost          string
+	APIURL              string
+	LastBuildID         uint64
+	LastBuildNumber     string
+	LastBuildStartedAt  *time.Time
+	LastBuildFinishedAt *time.Time
+	LastBuildDuration   time.Duration
+	LastBuildState      string
+	DefaultBranch       string
+	Description         string
+}
+
+type TimeoutsMetadata struct {
+	HardLimit  time.Duration
+	LogSilence time.Duration
+}
+
+// CacheSettings describe where a job's build cache is stored.  S3 is set for
+// the "s3" type and URL for the "file" type.
+type CacheSettings struct {
+	Type         string
+	FetchTimeout time.Duration
+	PushTimeout  time.Duration
+	S3           *S3CacheSettings
+	URL          string
+}
+
+type S3CacheSettings struct {
+	Hostname         string
+	Bucket           string
+	Region           string
+	AccessKeyID      string
+	SecretAccessKey  string
+	SignatureVersion string
+}
+
+type VMConfigMetadata struct {
+	GpuCount uint64
+	GpuType  string
+	Zone     string
+}
+
+func (j *jobWrapper) Metadata() *Metadata {
+	md := &Metadata{Config: map[string]interface{}{}}
+	if j.J == nil {
+		return md
+	}
+
+	md.ImageName = j.J.ImageName
+
+	data := j.data()
+	if data == nil {
+		return md
+	}
+
+	md.Type = data.Type
+	md.UUID = data.UUID
+	md.Queue = data.Queue
+	md.VMType = data.VMType
+	md.Trace = data.Trace
+	md.Warmer = data.Warmer
+	md.Enterprise = data.Enterprise
+	md.PreferHTTPS = data.PreferHTTPS
+
+	if data.Config != nil {
+		md.Config = copyConfigMap(data.Config)
+	}
+
+	if data.VMConfig != nil {
+		md.VMConfig = VMConfigMetadata{
+			GpuCount: data.VMConfig.GpuCount,
+			GpuType:  data.VMConfig.GpuType,
+			Zone:     data.VMConfig.Zone,
+		}
+	}
+
+	if data.Job != nil {
+		md.Job = JobMetadata{
+			ID:               data.Job.ID,
+			Number:           data.Job.Number,
+			QueuedAt:         copyTime(data.Job.QueuedAt),
+			Commit:           data.Job.Commit,
+			CommitRange:      data.Job.CommitRange,
+			CommitMessage:    data.Job.CommitMessage,
+			Branch:           data.Job.Branch,
+			Ref:              stringValue(data.Job.Ref),
+			Tag:              stringValue(data.Job.Tag),
+			PullRequest:      uint64(data.Job.PullRequest),
+			State:            data.Job.State,
+			SecureEnvEnabled: data.Job.SecureEnvEnabled,
+			SecureEnvRemoved: data.Job.SecureEnvRemoved,
+			DebugOptions:     copyConfigMap(data.Job.DebugOptions),
+			AllowFailure:     data.Job.AllowFailure,
+			StageName:        stringValue(data.Job.StageName),
+		}
+	}
+
+	if data.Build != nil {
+		md.Build = BuildMetadata{
+			ID:        data.Build.ID,
+			Number:    data.Build.Number,
+			EventType: data.Build.EventType,
+		}
+	}
+
+	if data.Repository != nil {
+		md.Repository = RepositoryMetadata{
+			ID:                  data.Repository.ID,
+			GithubID:            data.Repository.GithubID,
+			Private:             data.Repository.Private,
+			Slug:                data.Repository.Slug,
+			SourceURL:           data.Repository.SourceURL,
+			SourceHost:          data.Repository.SourceHost,
+			APIURL:              data.Repository.APIURL,
+			LastBuildID:         data.Repository.LastBuildID,
+			LastBuildNumber:     data.Repository.LastBuildNumber,
+			LastBuildStartedAt:  copyTime(data.Repository.LastBuildStartedAt),
+			LastBuildFinishedAt: copyTime(data.Repository.LastBuildFinishedAt),
+			LastBuildDuration:   time.Duration(data.Repository.LastBuildDuration) * time.Second,
+			LastBuildState:      data.Repository.LastBuildState,
+			DefaultBranch:       data.Repository.DefaultBranch,
+			Description:         data.Repository.Description,
+		}
+	}
+
+	if data.Timeouts != nil {
+		md.Timeouts = TimeoutsMetadata{
+			HardLimit:  time.Duration(data.Timeouts.HardLimit) * time.Second,
+			LogSilence: time.Duration(data.Timeouts.LogSilence) * time.Second,
+		}
+	}
+
+	if data.CacheSettings != nil {
+		md.CacheSettings = &CacheSettings{
+			Type:         data.CacheSettings.Type,
+			FetchTimeout: time.Duration(data.CacheSettings.FetchTimeout) * time.Second,
+			PushTimeout:  time.Duration(data.CacheSettings.PushTimeout) * time.Second,
+		}
+
+		if data.CacheSettings.S3 != nil {
+			md.CacheSettings.S3 = &S3CacheSettings{
+				Hostname:         data.CacheSettings.S3.Hostname,
+				Bucket:           data.CacheSettings.S3.Bucket,
+				Region:           data.CacheSettings.S3.Region,
+				AccessKeyID:      data.CacheSettings.S3.AccessKeyID,
+				SecretAccessKey:  data.CacheSettings.S3.SecretAccessKey,
+				SignatureVersion: data.CacheSettings.S3.AWSSignatureVersion,
+			}
+		}
+
+		if data.CacheSettings.File != nil {
+			md.CacheSettings.URL = data.CacheSettings.File.URL
+		}
+	}
+
+	return md
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+
+	return *s
+}
+
+// copyConfigMap deep copies a map decoded from JSON, so that nested maps and
+// slices are not shared either.
+func copyConfigMap(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+
+	c := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		c[k] = copyConfigValue(v)
+	}
+
+	return c
+}
+
+func copyConfigValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return copyConfigMap(v)
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, e := range v {
+			c[i] = copyConfigValue(e)
+		}
+		return c
+	default:
+		return v
+	}
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+
+	c := *t
+	return &c
+}
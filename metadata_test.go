@@ -0,0 +1,44 @@
+package job
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestJobWrapperMetadataIsACopy(t *testing.T) {
+	j := &job{}
+	err := json.Unmarshal([]byte(`{
+		"data": {
+			"config": {"language": "go", "go": ["1.20"], "addons": {"apt": {"packages": ["curl"]}}},
+			"job": {"id": 42, "queued_at": "2019-01-02T03:04:05Z", "debug_options": {"stage": "before_install"}}
+		}
+	}`), j)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jw := &jobWrapper{J: j}
+
+	md := jw.Metadata()
+	md.Config["language"] = "ruby"
+	md.Config["go"].([]interface{})[0] = "1.11"
+	md.Config["addons"].(map[string]interface{})["apt"].(map[string]interface{})["packages"] = nil
+	md.Job.DebugOptions["stage"] = "script"
+	*md.Job.QueuedAt = md.Job.QueuedAt.AddDate(1, 0, 0)
+
+	md = jw.Metadata()
+	for _, tc := range []struct {
+		name             string
+		actual, expected interface{}
+	}{
+		{"language", md.Config["language"], "go"},
+		{"go", md.Config["go"].([]interface{})[0], "1.20"},
+		{"apt packages", md.Config["addons"].(map[string]interface{})["apt"].(map[string]interface{})["packages"].([]interface{})[0], "curl"},
+		{"debug stage", md.Job.DebugOptions["stage"], "before_install"},
+		{"queued at year", md.Job.QueuedAt.Year(), 2019},
+	} {
+		if tc.actual != tc.expected {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, tc.actual)
+		}
+	}
+}
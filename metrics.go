@@ -0,0 +1,264 @@
+package job
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	metricsNamespace = "travis_job"
+)
+
+// Metrics holds the Prometheus metrics describing a processor and the jobs it
+// runs, as seen through the Source, Runner, Statuser and Streamer wrapped by
+// NewMetricsSource, NewMetricsRunner, NewMetricsStatuser and
+// NewMetricsStreamer.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	fetches       *prometheus.CounterVec
+	fetchDuration *prometheus.HistogramVec
+	queueDuration prometheus.Histogram
+	runDuration   *prometheus.HistogramVec
+	statusErrors  prometheus.Counter
+	streamErrors  *prometheus.CounterVec
+	streamedBytes *prometheus.CounterVec
+
+	mu         sync.Mutex
+	receivedAt map[string]time.Time
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		fetches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "source_fetches_total",
+			Help:      "Job fetches by result, which is one of job, no_job or error.",
+		}, []string{"result"}),
+		fetchDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "source_fetch_duration_seconds",
+			Help:      "Time taken by job fetches by result.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"result"}),
+		queueDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "queue_duration_seconds",
+			Help:      "Time from a job being queued to it being received.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 14),
+		}),
+		runDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "run_duration_seconds",
+			Help:      "Time from a job being received to its final state, by final state.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 16),
+		}, []string{"state"}),
+		statusErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "status_errors_total",
+			Help:      "Job state updates that failed.",
+		}),
+		streamErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "stream_errors_total",
+			Help:      "Job streams that failed, by stream.",
+		}, []string{"stream"}),
+		streamedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "streamed_bytes_total",
+			Help:      "Bytes of job output streamed, by stream.",
+		}, []string{"stream"}),
+		receivedAt: map[string]time.Time{},
+	}
+
+	m.registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		m.fetches,
+		m.fetchDuration,
+		m.queueDuration,
+		m.runDuration,
+		m.statusErrors,
+		m.streamErrors,
+		m.streamedBytes,
+	)
+
+	return m
+}
+
+// Serve serves the metrics on /metrics at listenAddr until the context is
+// done.
+func (m *Metrics) Serve(ctx context.Context, log logrus.FieldLogger, listenAddr string) error {
+	log = log.WithField("self", "metrics_server")
+
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return errors.Wrap(err, "failed to listen for metrics")
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+
+	server := &http.Server{Handler: mux}
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+
+	log.WithField("addr", listener.Addr().String()).Info("serving metrics")
+	err = server.Serve(listener)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+
+	return err
+}
+
+func (m *Metrics) recordState(job Job, state State) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	switch state {
+	case ReceivedState:
+		m.receivedAt[job.ID()] = now
+
+		queuedAt := job.Metadata().Job.QueuedAt
+		if queuedAt != nil {
+			m.queueDuration.Observe(now.Sub(*queuedAt).Seconds())
+		}
+	case PassedState, FailedState, ErroredState, CanceledState, RestartedState, WarmedState:
+		receivedAt, ok := m.receivedAt[job.ID()]
+		if !ok {
+			return
+		}
+
+		delete(m.receivedAt, job.ID())
+		m.runDuration.WithLabelValues(string(state)).Observe(now.Sub(receivedAt).Seconds())
+	}
+}
+
+// finishJob forgets a job once it is no longer running, whether or not it
+// reached a final state.
+func (m *Metrics) finishJob(job Job) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.receivedAt, job.ID())
+}
+
+// NewMetricsSource wraps a Source, counting and timing its fetches in m.
+func NewMetricsSource(src Source, m *Metrics) Source {
+	return &metricsSource{src: src, metrics: m}
+}
+
+type metricsSource struct {
+	src     Source
+	metrics *Metrics
+}
+
+func (ms *metricsSource) Fetch(ctx context.Context) (Job, error) {
+	start := time.Now()
+	job, err := ms.src.Fetch(ctx)
+	if ctx.Err() != nil {
+		return job, err
+	}
+
+	result := "job"
+	switch {
+	case err == nil:
+	case errors.Cause(err) == remoteSourceNoJobErr:
+		result = "no_job"
+	default:
+		result = "error"
+	}
+
+	ms.metrics.fetches.WithLabelValues(result).Inc()
+	ms.metrics.fetchDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+	return job, err
+}
+
+// NewMetricsRunner wraps a Runner, forgetting every job in m once it has run.
+func NewMetricsRunner(runner Runner, m *Metrics) Runner {
+	return &metricsRunner{runner: runner, metrics: m}
+}
+
+type metricsRunner struct {
+	runner  Runner
+	metrics *Metrics
+}
+
+func (mr *metricsRunner) Run(ctx context.Context, job Job) error {
+	defer mr.metrics.finishJob(job)
+	return mr.runner.Run(ctx, job)
+}
+
+// NewMetricsStatuser wraps a Statuser, timing jobs by their state updates and
+// counting failed updates in m.
+func NewMetricsStatuser(statuser Statuser, m *Metrics) Statuser {
+	return &metricsStatuser{statuser: statuser, metrics: m}
+}
+
+type metricsStatuser struct {
+	statuser Statuser
+	metrics  *Metrics
+}
+
+func (ms *metricsStatuser) Status(ctx context.Context, job Job, stateUpdate StateUpdate) error {
+	ms.metrics.recordState(job, stateUpdate.New())
+
+	err := ms.statuser.Status(ctx, job, stateUpdate)
+	if err != nil {
+		ms.metrics.statusErrors.Inc()
+	}
+
+	return err
+}
+
+// NewMetricsStreamer wraps a Streamer, counting the bytes written to each
+// stream's dest and failed streams in m.
+func NewMetricsStreamer(streamer Streamer, m *Metrics) Streamer {
+	return &metricsStreamer{streamer: streamer, metrics: m}
+}
+
+type metricsStreamer struct {
+	streamer Streamer
+	metrics  *Metrics
+}
+
+func (ms *metricsStreamer) Stream(ctx context.Context, job Job, str Stream) error {
+	if str.Dest() != nil {
+		str.SetDest(&countingWriter{
+			w:       str.Dest(),
+			counter: ms.metrics.streamedBytes.WithLabelValues(str.Name()),
+		})
+	}
+
+	err := ms.streamer.Stream(ctx, job, str)
+	if err != nil && err != context.Canceled {
+		ms.metrics.streamErrors.WithLabelValues(str.Name()).Inc()
+	}
+
+	return err
+}
+
+type countingWriter struct {
+	w       io.Writer
+	counter prometheus.Counter
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.counter.Add(float64(n))
+	return n, err
+}
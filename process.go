@@ -0,0 +1,55 @@
+package job
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	processGroupPollInterval = 50 * time.Millisecond
+	processGroupReapTimeout  = 5 * time.Second
+)
+
+// processGroup is a job process together with every descendant it spawned,
+// so that background processes left behind by a job can be stopped with it.
+type processGroup struct {
+	proc *os.Process
+}
+
+func newProcessGroup(proc *os.Process) *processGroup {
+	return &processGroup{proc: proc}
+}
+
+// stop asks the whole group to terminate and kills whatever is still running
+// after the grace period.  It returns an error if any process remains once
+// the group has been killed.
+func (pg *processGroup) stop(grace time.Duration) error {
+	if !pg.alive() {
+		return nil
+	}
+
+	_ = pg.interrupt()
+	if pg.waitGone(grace) {
+		return nil
+	}
+
+	_ = pg.kill()
+	if pg.waitGone(processGroupReapTimeout) {
+		return nil
+	}
+
+	return fmt.Errorf("processes remain in group %d", pg.proc.Pid)
+}
+
+func (pg *processGroup) waitGone(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for pg.alive() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(processGroupPollInterval)
+	}
+
+	return true
+}
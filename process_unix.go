@@ -0,0 +1,78 @@
+//go:build !windows
+// +build !windows
+
+package job
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts the command in a new session, making it the leader
+// of a process group that all of its descendants inherit.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setsid = true
+}
+
+func (pg *processGroup) interrupt() error {
+	return syscall.Kill(-pg.proc.Pid, syscall.SIGTERM)
+}
+
+func (pg *processGroup) kill() error {
+	return syscall.Kill(-pg.proc.Pid, syscall.SIGKILL)
+}
+
+// alive reports whether any process in the group is still running.  Exited
+// group members that were reparented to this process, as happens when
+// running as pid 1, are reaped along the way.
+func (pg *processGroup) alive() bool {
+	for {
+		var status syscall.WaitStatus
+		pid, err := syscall.Wait4(-pg.proc.Pid, &status, syscall.WNOHANG, nil)
+		if err != nil || pid <= 0 {
+			break
+		}
+	}
+
+	err := syscall.Kill(-pg.proc.Pid, syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}
+
+// processSignalName returns the name of the signal that terminated the
+// process, or "" if it exited normally.
+func processSignalName(ps *os.ProcessState) string {
+	status, ok := ps.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return ""
+	}
+
+	if name, ok := signalNames[status.Signal()]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("SIG%d", int(status.Signal()))
+}
+
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGABRT: "SIGABRT",
+	syscall.SIGALRM: "SIGALRM",
+	syscall.SIGBUS:  "SIGBUS",
+	syscall.SIGFPE:  "SIGFPE",
+	syscall.SIGHUP:  "SIGHUP",
+	syscall.SIGILL:  "SIGILL",
+	syscall.SIGINT:  "SIGINT",
+	syscall.SIGKILL: "SIGKILL",
+	syscall.SIGPIPE: "SIGPIPE",
+	syscall.SIGQUIT: "SIGQUIT",
+	syscall.SIGSEGV: "SIGSEGV",
+	syscall.SIGTERM: "SIGTERM",
+	syscall.SIGUSR1: "SIGUSR1",
+	syscall.SIGUSR2: "SIGUSR2",
+	syscall.SIGXCPU: "SIGXCPU",
+	syscall.SIGXFSZ: "SIGXFSZ",
+}
@@ -0,0 +1,29 @@
+//go:build windows
+// +build windows
+
+package job
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup is a no-op on windows, where only the job process itself
+// can be stopped.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func (pg *processGroup) interrupt() error {
+	return pg.proc.Kill()
+}
+
+func (pg *processGroup) kill() error {
+	return pg.proc.Kill()
+}
+
+func (pg *processGroup) alive() bool {
+	return false
+}
+
+func processSignalName(ps *os.ProcessState) string {
+	return ""
+}
@@ -0,0 +1,37 @@
+//go:build !windows
+// +build !windows
+
+package job
+
+import (
+	"os"
+	"os/exec"
+
+	"github.com/creack/pty"
+)
+
+// openPTY opens a pseudo-terminal of the given size, returning its master and
+// slave ends.
+func openPTY(cols, rows uint16) (*os.File, *os.File, error) {
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = pty.Setsize(ptmx, &pty.Winsize{Cols: cols, Rows: rows})
+	if err != nil {
+		ptmx.Close()
+		tty.Close()
+		return nil, nil, err
+	}
+
+	return ptmx, tty, nil
+}
+
+// setControllingTerminal makes the command's stdin, which must be a terminal,
+// the controlling terminal of its session.
+func setControllingTerminal(cmd *exec.Cmd) {
+	setProcessGroup(cmd)
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Ctty = 0
+}
@@ -0,0 +1,16 @@
+//go:build windows
+// +build windows
+
+package job
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+func openPTY(cols, rows uint16) (*os.File, *os.File, error) {
+	return nil, nil, fmt.Errorf("pseudo-terminals are not supported on windows")
+}
+
+func setControllingTerminal(cmd *exec.Cmd) {}
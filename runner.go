@@ -7,52 +7,208 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
-	"path"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	defaultKillGracePeriod = 10 * time.Second
+	finalStatusTimeout     = 30 * time.Second
+	defaultPTYColumns      = 80
+	defaultPTYRows         = 24
+)
+
 type Runner interface {
 	Run(context.Context, Job) error
 }
 
-func NewRunner(log logrus.FieldLogger, statuser Statuser, streamer Streamer) (Runner, error) {
+// RunnerConfig holds the processor-side settings applied to every job run by
+// a Runner.
+type RunnerConfig struct {
+	// ScriptVerifier, when set, must accept a job's script before it is
+	// written and executed.
+	ScriptVerifier ScriptVerifier
+
+	// Interpreters are the interpreters job scripts may be run with, and
+	// defaults to NewInterpreterRegistry.
+	Interpreters *InterpreterRegistry
+
+	// WorkspaceRoot is where per-job workspaces are created, and defaults to
+	// os.TempDir().
+	WorkspaceRoot string
+
+	// KeepWorkspace leaves job workspaces in place after the job has run.
+	KeepWorkspace bool
+
+	// KillGracePeriod is how long a job's processes are given to exit after
+	// SIGTERM before they are sent SIGKILL, and defaults to
+	// defaultKillGracePeriod.
+	KillGracePeriod time.Duration
+
+	// Limits are the resource limits applied to job processes.
+	Limits *ResourceLimits
+
+	// ExitStates maps job process exit codes and signals to final job
+	// states.
+	ExitStates *ExitStates
+
+	// Debug sets TRAVIS_DEBUG in the job environment.
+	Debug bool
+
+	// DebugHold, when set, keeps a finished job's workspace and environment
+	// for up to this long and serves DebugShell sessions in them over a unix
+	// socket before the final state is reported.
+	DebugHold  time.Duration
+	DebugShell string
+
+	// WarmupCommand is run via "sh -c" for warmer jobs, which do not run
+	// their job script.
+	WarmupCommand string
+
+	// CacheURL, when set, is a file:// URL used as the build cache for every
+	// job instead of the job's cache settings.
+	CacheURL string
+
+	// Checkout clones the job's repository into the build dir at the job's
+	// commit before the script runs, fetching CheckoutDepth commits of
+	// history, or all of it if CheckoutDepth is 0.
+	Checkout      bool
+	CheckoutDepth int
+
+	// ArtifactPaths are glob patterns of workspace files uploaded to the job's
+	// artifacts URL once its script has run.
+	ArtifactPaths []string
+
+	// PreRunHook and PostRunHook are run via "sh -c" before and after every
+	// job, with the path of the job's payload in TRAVIS_JOB_PAYLOAD and, for
+	// PostRunHook, the job's final state in TRAVIS_JOB_STATE.  A failing
+	// PreRunHook marks the job errored without running it.
+	PreRunHook  string
+	PostRunHook string
+
+	// PTY runs job scripts attached to a pseudo-terminal of PTYColumns by
+	// PTYRows instead of a pipe, for tools that only behave interactively
+	// on a terminal.
+	PTY        bool
+	PTYColumns uint16
+	PTYRows    uint16
+
+	// CleanEnv starts job processes from a minimal environment instead of
+	// travis-job's own.
+	CleanEnv bool
+
+	// EchoEnvVars writes the job's repository environment variables, with
+	// all but public values masked, at the top of the job log.
+	EchoEnvVars bool
+}
+
+func NewRunner(log logrus.FieldLogger, statuser Statuser, streamer Streamer, cfg *RunnerConfig) (Runner, error) {
+	return newExecRunner(log, statuser, streamer, cfg)
+}
+
+func newExecRunner(log logrus.FieldLogger, statuser Statuser, streamer Streamer, cfg *RunnerConfig) (*execRunner, error) {
+	if cfg == nil {
+		cfg = &RunnerConfig{}
+	}
+
+	if cfg.KillGracePeriod == 0 {
+		cfg.KillGracePeriod = defaultKillGracePeriod
+	}
+
+	if cfg.DebugShell == "" {
+		cfg.DebugShell = defaultDebugShell
+	}
+
+	if cfg.PTYColumns == 0 {
+		cfg.PTYColumns = defaultPTYColumns
+	}
+
+	if cfg.PTYRows == 0 {
+		cfg.PTYRows = defaultPTYRows
+	}
+
+	if cfg.Interpreters == nil {
+		cfg.Interpreters = NewInterpreterRegistry()
+	}
+
 	return &execRunner{
-		interpreter: "bash",
-		log:         log.WithField("self", "exec_runner"),
-		statuser:    statuser,
-		streamer:    streamer,
+		cfg:      cfg,
+		log:      log.WithField("self", "exec_runner"),
+		statuser: statuser,
+		streamer: streamer,
 	}, nil
 }
 
 type execRunner struct {
-	interpreter string
-	log         logrus.FieldLogger
-	statuser    Statuser
-	streamer    Streamer
+	cfg      *RunnerConfig
+	log      logrus.FieldLogger
+	statuser Statuser
+	streamer Streamer
 }
 
 func (er *execRunner) Run(ctx context.Context, job Job) error {
-	log := er.log.WithFields(logrus.Fields{
-		"job_id": job.ID(),
-	})
+	log := er.jobLog(ctx, job)
 	er.status(ctx, job, QueuedState, ReceivedState)
 
+	if er.cfg.PreRunHook != "" || er.cfg.PostRunHook != "" {
+		return er.runWithHooks(ctx, log, job)
+	}
+
+	return er.run(ctx, log, job)
+}
+
+// run runs a job that has been received, reporting its state from then on.
+func (er *execRunner) run(ctx context.Context, log logrus.FieldLogger, job Job) error {
+	if job.Metadata().Warmer {
+		return er.warm(ctx, log, job)
+	}
+
 	log.Debug("extracting script")
-	script, err := job.Script()
+	script, err := job.Script(ctx)
 	if err != nil {
 		log.WithError(err).Error("failed to extract job script")
+		er.status(ctx, job, ReceivedState, ErroredState)
 		return errors.Wrap(err, "failed to extract job script")
 	}
 
-	dest := path.Join(os.TempDir(), fmt.Sprintf("travis-job-%v.%s", job.ID(), er.interpreter))
+	if er.cfg.ScriptVerifier != nil {
+		log.Debug("verifying script")
+		err = er.cfg.ScriptVerifier.Verify(job, script)
+		if err != nil {
+			log.WithError(err).Error("failed to verify job script")
+			er.status(ctx, job, ReceivedState, ErroredState)
+			return errors.Wrap(err, "failed to verify job script")
+		}
+	}
+
+	interp, err := er.cfg.Interpreters.Select(job, script)
+	if err != nil {
+		log.WithError(err).Error("failed to select job script interpreter")
+		er.status(ctx, job, ReceivedState, ErroredState)
+		return errors.Wrap(err, "failed to select job script interpreter")
+	}
+
+	ws, err := newWorkspace(er.cfg.WorkspaceRoot, job.ID())
+	if err != nil {
+		log.WithError(err).Error("failed to create workspace")
+		er.status(ctx, job, ReceivedState, ErroredState)
+		return errors.Wrap(err, "failed to create workspace")
+	}
+
+	defer er.cleanupWorkspace(log, ws)
+
+	dest := ws.scriptPath(interp.extension())
 	log.WithFields(logrus.Fields{
-		"dest": dest,
-		"len":  len(script),
+		"interpreter": interp.Name,
+		"dest":        dest,
+		"len":         len(script),
 	}).Debug("writing script")
-	err = ioutil.WriteFile(dest, []byte(script), os.FileMode(0755))
+	err = ioutil.WriteFile(dest, []byte(script), os.FileMode(0700))
 	if err != nil {
+		log.WithError(err).Error("failed to write job script")
+		er.status(ctx, job, ReceivedState, ErroredState)
 		return errors.Wrap(err, "failed to write job script")
 	}
 
@@ -68,64 +224,402 @@ func (er *execRunner) Run(ctx context.Context, job Job) error {
 	stdOutErr := job.Streams()[stdOutErrName]
 
 	log.Debug("starting stdouterr streamer")
+	pw := er.startStream(ctx, log, job, stdOutErr)
+	defer pw.Close()
 
-	pr, pw := io.Pipe()
-	stdOutErr.SetSource(pr)
+	envVars := job.EnvVars()
+	log.WithField("count", len(envVars)).Debug("setting job env vars")
 
-	go func() {
-		err := er.streamer.Stream(ctx, job, stdOutErr)
+	secrets := envVarsSecrets(envVars)
+	out, flushOut := maskSecrets(pw, secrets)
+	defer flushOut()
+
+	if er.cfg.EchoEnvVars {
+		err = writeEnvVarsEcho(out, envVars)
+		if err != nil {
+			log.WithError(err).Error("failed to echo env vars")
+		}
+	}
+
+	if er.cfg.Checkout {
+		log.Debug("checking out source")
+		err = er.checkout(ctx, log, job, ws, out)
 		if err != nil {
-			log.WithError(err).Error("failure during streaming")
+			log.WithError(err).Error("failed to check out source")
+			fmt.Fprintf(out, "\nFailed to check out source: %v\n", err)
+			er.status(ctx, job, ReceivedState, ErroredState)
+			return errors.Wrap(err, "failed to check out source")
 		}
+	}
+
+	jc, err := newJobCache(job, ws, er.cfg.CacheURL)
+	if err != nil {
+		log.WithError(err).Warn("running job without build cache")
+		fmt.Fprintf(out, "Not using the build cache: %v\n", err)
+	}
+
+	if jc != nil {
+		jc.fetch(ctx, log, out)
+	}
+
+	sc := er.command(log, job, interp, ws, jc)
+	cmd := exec.Command(sc.name, sc.args...)
+	cmd.Dir = ws.buildDir
+	cmd.Env = sc.env
+	setProcessGroup(cmd)
+
+	cg, err := newJobCgroup(er.cfg.Limits, job.ID())
+	if err != nil {
+		log.WithError(err).Error("failed to create job cgroup")
+		fmt.Fprintf(out, "\nFailed to apply resource limits: %v\n", err)
+		er.statusWithMeta(ctx, job, ReceivedState, ErroredState, map[string]interface{}{"error": err.Error()})
+		return errors.Wrap(err, "failed to create job cgroup")
+	}
+
+	if cg != nil {
+		cg.apply(cmd)
+		defer func() {
+			err := cg.remove()
+			if err != nil {
+				log.WithError(err).Warn("failed to remove job cgroup")
+			}
+		}()
+	}
+
+	sampler, err := newLimitSampler(er.cfg.Limits, cg)
+	if err != nil {
+		log.WithError(err).Error("failed to sample job processes")
+		fmt.Fprintf(out, "\nFailed to apply resource limits: %v\n", err)
+		er.statusWithMeta(ctx, job, ReceivedState, ErroredState, map[string]interface{}{"error": err.Error()})
+		return errors.Wrap(err, "failed to sample job processes")
+	}
+
+	outR, outW, err := er.outputPipe(cmd)
+	if err != nil {
+		log.WithError(err).Error("failed to create output pipe")
+		er.status(ctx, job, ReceivedState, ErroredState)
+		return errors.Wrap(err, "failed to create output pipe")
+	}
+
+	defer outR.Close()
+
+	outputDone := []chan struct{}{make(chan struct{})}
+	go func() {
+		_, _ = io.Copy(out, outR)
+		close(outputDone[0])
 	}()
 
-	cmd := exec.CommandContext(ctx, er.interpreter, dest)
-	cmd.Stdout = pw
-	cmd.Stderr = pw
+	var traceW *os.File
+	if sc.trace {
+		var traceR *os.File
+		traceR, traceW, err = os.Pipe()
+		if err != nil {
+			log.WithError(err).Error("failed to create trace pipe")
+			er.status(ctx, job, ReceivedState, ErroredState)
+			return errors.Wrap(err, "failed to create trace pipe")
+		}
+
+		defer traceR.Close()
+
+		cmd.ExtraFiles = []*os.File{traceW}
+
+		traceStream, ok := job.Streams()[traceStreamName]
+		if !ok {
+			traceStream = NewNamedStream(traceStreamName)
+			traceStream.SetDest(os.Stderr)
+		}
+
+		log.Debug("starting trace streamer")
+		tracePW := er.startStream(ctx, log, job, traceStream)
+		defer tracePW.Close()
+
+		traceOut, flushTrace := maskSecrets(tracePW, secrets)
+		defer flushTrace()
+
+		traced := make(chan struct{})
+		outputDone = append(outputDone, traced)
+		go func() {
+			err := copyTimestampedLines(traceOut, traceR)
+			if err != nil {
+				log.WithError(err).Warn("failed to copy trace")
+			}
+			close(traced)
+		}()
+	}
 
 	er.status(ctx, job, ReceivedState, StartedState)
 	log.Debug("starting command")
 	err = cmd.Start()
+	outW.Close()
+	if traceW != nil {
+		traceW.Close()
+	}
+
 	if err != nil {
 		log.WithError(err).Error("failed to start command")
-		er.status(ctx, job, StartedState, FailedState)
+		fmt.Fprintf(out, "\nFailed to start the job script: %v\n", err)
+		er.statusWithMeta(ctx, job, StartedState, ErroredState, map[string]interface{}{"error": err.Error()})
 		return errors.Wrap(err, "failed to start command")
 	}
 
-	err = cmd.Wait()
-	if err != nil {
-		log.WithError(err).Error("command wait errored")
+	sampler.start(cmd.Process.Pid)
+	err = er.wait(ctx, log, cmd)
+
+	log.Debug("stopping remaining processes")
+	stopErr := newProcessGroup(cmd.Process).stop(er.cfg.KillGracePeriod)
+	sampler.stop()
 
-		if cmd.ProcessState == nil {
-			er.status(ctx, job, StartedState, ErroredState)
-			return errors.Wrap(err, "no process state found")
+	outputTimeout := time.After(er.cfg.KillGracePeriod)
+	for _, done := range outputDone {
+		select {
+		case <-done:
+		case <-outputTimeout:
+			log.Warn("timed out waiting for command output to close")
 		}
+	}
 
-		if !cmd.ProcessState.Exited() {
-			er.status(ctx, job, StartedState, ErroredState)
-			return errors.Wrap(err, "process did not exit")
+	if er.cfg.DebugHold > 0 && ctx.Err() == nil {
+		hold := &debugHold{
+			log:     log,
+			shell:   er.cfg.DebugShell,
+			timeout: er.cfg.DebugHold,
+			ws:      ws,
+			env:     cmd.Env,
 		}
 
-		if !cmd.ProcessState.Success() {
-			er.status(ctx, job, StartedState, FailedState)
-			return errors.Wrap(err, "process exited without success")
+		holdErr := hold.run(ctx, out)
+		if holdErr != nil {
+			log.WithError(holdErr).Error("failed to hold job for debugging")
 		}
+	}
 
-		er.status(ctx, job, StartedState, FailedState)
-		return err
+	if cmd.ProcessState == nil {
+		log.WithError(err).Error("command wait errored")
+		er.status(ctx, job, StartedState, ErroredState)
+		return errors.Wrap(err, "no process state found")
+	}
+
+	meta := map[string]interface{}{
+		"exit_code": cmd.ProcessState.ExitCode(),
+	}
+
+	signal := processSignalName(cmd.ProcessState)
+	if signal != "" {
+		meta["signal"] = signal
+	}
+
+	if stopErr != nil {
+		log.WithError(stopErr).Error("failed to stop remaining processes")
+		er.statusWithMeta(ctx, job, StartedState, ErroredState, meta)
+		return errors.Wrap(stopErr, "failed to stop remaining processes")
+	}
+
+	if len(er.cfg.ArtifactPaths) > 0 && ctx.Err() == nil {
+		meta["artifacts"] = er.uploadArtifacts(ctx, log, job, ws, er.cfg.ArtifactPaths, out)
+	}
+
+	breach := er.cfg.Limits.breach(cmd.ProcessState, cg, sampler, ws.dir)
+	if breach != "" {
+		log.WithField("breach", breach).Error("job exceeded resource limits")
+		fmt.Fprintf(out, "\n\nThe job exceeded its resource limits: %s\n", breach)
+		meta["limit_breach"] = breach
+		er.statusWithMeta(ctx, job, StartedState, ErroredState, meta)
+		return fmt.Errorf("job exceeded resource limits: %s", breach)
+	}
+
+	finalState := er.cfg.ExitStates.state(cmd.ProcessState.ExitCode(), signal)
+	log.WithFields(logrus.Fields{
+		"exit_code": cmd.ProcessState.ExitCode(),
+		"signal":    signal,
+		"state":     finalState,
+	}).Debug("command completed")
+
+	if jc != nil && (finalState == PassedState || finalState == FailedState) {
+		jc.push(ctx, log, out)
+	}
+
+	er.statusWithMeta(ctx, job, StartedState, finalState, meta)
+
+	if finalState != PassedState {
+		if err == nil {
+			err = fmt.Errorf("exit status %d", cmd.ProcessState.ExitCode())
+		}
+		log.WithError(err).Error("command wait errored")
+		return errors.Wrapf(err, "job finished as %s", finalState)
 	}
 
-	er.status(ctx, job, StartedState, PassedState)
-	log.Debug("command completed")
 	return nil
 }
 
+// scriptCommand is how a job's script is run.
+type scriptCommand struct {
+	name  string
+	args  []string
+	env   []string
+	trace bool
+}
+
+// command assembles the command and environment the job's script, written to
+// the workspace for interp, is run with.  The dry runner uses it too, so that
+// what it shows is what would run.
+func (er *execRunner) command(log logrus.FieldLogger, job Job, interp *Interpreter, ws *workspace, jc *jobCache) *scriptCommand {
+	sc := &scriptCommand{trace: job.Metadata().Trace && interp.supportsTrace()}
+	if job.Metadata().Trace && !sc.trace {
+		log.WithField("interpreter", interp.Name).Warn("tracing is not supported by interpreter")
+	}
+
+	scriptPath := ws.scriptPath(interp.extension())
+	sc.name, sc.args = interp.commandFor(scriptPath)
+	if sc.trace {
+		sc.name, sc.args = interp.tracedCommandFor(scriptPath)
+	}
+	sc.name, sc.args = er.cfg.Limits.wrapCommand(sc.name, sc.args)
+
+	sc.env = append(append(er.baseEnviron(), ws.environ()...), travisEnviron(job)...)
+	sc.env = append(sc.env, envVarsEnviron(job.EnvVars())...)
+	if er.cfg.Debug {
+		sc.env = append(sc.env, "TRAVIS_DEBUG=true")
+	}
+	if jc != nil {
+		sc.env = append(sc.env, jc.environ()...)
+	}
+	if sc.trace {
+		sc.env = append(sc.env, fmt.Sprintf("BASH_XTRACEFD=%d", traceFD))
+	}
+	if er.cfg.PTY {
+		sc.env = append(sc.env, "TERM=xterm")
+	}
+
+	return sc
+}
+
+// outputPipe connects the command's stdout and stderr, and with PTY its
+// stdin, to the write end of a new pipe or pseudo-terminal and returns both
+// ends.  The write end must be closed once the command has started.
+func (er *execRunner) outputPipe(cmd *exec.Cmd) (*os.File, *os.File, error) {
+	if !er.cfg.PTY {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		cmd.Stdout = w
+		cmd.Stderr = w
+		return r, w, nil
+	}
+
+	ptmx, tty, err := openPTY(er.cfg.PTYColumns, er.cfg.PTYRows)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to open pseudo-terminal")
+	}
+
+	cmd.Stdin = tty
+	cmd.Stdout = tty
+	cmd.Stderr = tty
+	setControllingTerminal(cmd)
+	return ptmx, tty, nil
+}
+
+// wait waits for the command to exit.  If the context is done first, the
+// command's process group is sent SIGTERM and, if the command has not exited
+// by the end of the grace period, SIGKILL.
+func (er *execRunner) wait(ctx context.Context, log logrus.FieldLogger, cmd *exec.Cmd) error {
+	waitErrs := make(chan error, 1)
+	go func() {
+		waitErrs <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-waitErrs:
+		return err
+	case <-ctx.Done():
+	}
+
+	pg := newProcessGroup(cmd.Process)
+
+	log.WithError(ctx.Err()).Info("terminating command")
+	err := pg.interrupt()
+	if err != nil {
+		log.WithError(err).Debug("failed to interrupt process group")
+	}
+
+	select {
+	case err := <-waitErrs:
+		return err
+	case <-time.After(er.cfg.KillGracePeriod):
+	}
+
+	log.Warn("killing command")
+	err = pg.kill()
+	if err != nil {
+		log.WithError(err).Debug("failed to kill process group")
+	}
+
+	return <-waitErrs
+}
+
+// jobLog returns the runner's logger for a job, including the pool slot it
+// runs in, if any.
+func (er *execRunner) jobLog(ctx context.Context, job Job) logrus.FieldLogger {
+	log := er.log.WithField("job_id", job.ID())
+	if slot, ok := slotFromContext(ctx); ok {
+		log = log.WithField("slot", slot)
+	}
+
+	return log
+}
+
+// startStream streams everything written to the returned pipe writer via the
+// runner's streamer until the writer is closed or the context is done.  Jobs
+// running in a pool slot have each line they stream to travis-job's own
+// stdout or stderr prefixed with their ID, as other jobs share it.
+func (er *execRunner) startStream(ctx context.Context, log logrus.FieldLogger, job Job, str Stream) *io.PipeWriter {
+	pr, pw := io.Pipe()
+	str.SetSource(pr)
+
+	if _, ok := slotFromContext(ctx); ok && (str.Dest() == os.Stdout || str.Dest() == os.Stderr) {
+		str.SetDest(newLinePrefixWriter(str.Dest(), fmt.Sprintf("[job %s] ", job.ID())))
+	}
+
+	go func() {
+		err := er.streamer.Stream(ctx, job, str)
+		if err != nil && err != context.Canceled {
+			log.WithError(err).WithField("stream", str.Name()).Error("failure during streaming")
+		}
+	}()
+
+	return pw
+}
+
+func (er *execRunner) cleanupWorkspace(log logrus.FieldLogger, ws *workspace) {
+	log = log.WithField("workspace", ws.dir)
+	if er.cfg.KeepWorkspace {
+		log.Info("keeping workspace")
+		return
+	}
+
+	log.Debug("removing workspace")
+	err := ws.remove()
+	if err != nil {
+		log.WithError(err).Error("failed to remove workspace")
+	}
+}
+
 func (er *execRunner) status(ctx context.Context, job Job, curState, newState State) {
-	log := er.log.WithFields(logrus.Fields{
-		"job_id": job.ID(),
-	})
+	er.statusWithMeta(ctx, job, curState, newState, nil)
+}
+
+func (er *execRunner) statusWithMeta(ctx context.Context, job Job, curState, newState State, meta map[string]interface{}) {
+	log := er.jobLog(ctx, job)
+
+	if ctx.Err() != nil {
+		// The job's context is done, but its state still needs reporting.
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), finalStatusTimeout)
+		defer cancel()
+	}
 
-	statusErr := er.statuser.Status(ctx, job, NewStateUpdate(job.ID(), curState, newState))
+	statusErr := er.statuser.Status(ctx, job, NewStateUpdateWithMeta(job.ID(), curState, newState, meta))
 	if statusErr != nil {
 		log.WithError(statusErr).Error("failed to set job status")
 	}
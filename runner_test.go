@@ -0,0 +1,202 @@
+package job
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type testStatuser struct {
+	mu      sync.Mutex
+	updates []StateUpdate
+}
+
+func (ts *testStatuser) Status(ctx context.Context, job Job, stateUpdate StateUpdate) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.updates = append(ts.updates, stateUpdate)
+	return nil
+}
+
+func (ts *testStatuser) last() StateUpdate {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if len(ts.updates) == 0 {
+		return nil
+	}
+
+	return ts.updates[len(ts.updates)-1]
+}
+
+type testStreamer struct{}
+
+func (testStreamer) Stream(ctx context.Context, job Job, str Stream) error {
+	dest := str.Dest()
+	if dest == nil {
+		dest = ioutil.Discard
+	}
+
+	_, err := io.Copy(dest, str.Source())
+	return err
+}
+
+// lockedBuffer is a bytes.Buffer safe to read while a stream writes to it.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (lb *lockedBuffer) Write(p []byte) (int, error) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return lb.buf.Write(p)
+}
+
+func (lb *lockedBuffer) String() string {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return lb.buf.String()
+}
+
+// runTestJob runs script as job 42 with cfg, returning the final state update
+// and the job's output.
+func runTestJob(t *testing.T, cfg *RunnerConfig, script string) (StateUpdate, string) {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+
+	out := &lockedBuffer{}
+	str := NewNamedStream(stdOutErrName)
+	str.SetDest(out)
+
+	job := &jobWrapper{J: &job{
+		Data: &jobData{
+			Job:     &jobDataJob{ID: 42},
+			Streams: map[string]Stream{stdOutErrName: str},
+		},
+		JobScript: &jobJobScript{Name: "main.bash", Encoding: "plain", Content: script},
+	}}
+
+	if cfg.WorkspaceRoot == "" {
+		cfg.WorkspaceRoot = t.TempDir()
+	}
+	if cfg.KillGracePeriod == 0 {
+		cfg.KillGracePeriod = time.Second
+	}
+
+	statuser := &testStatuser{}
+	runner, err := NewRunner(log, statuser, testStreamer{}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = runner.Run(context.Background(), job)
+
+	// output is streamed asynchronously, so give it a moment to arrive
+	time.Sleep(50 * time.Millisecond)
+
+	su := statuser.last()
+	if su == nil {
+		t.Fatal("no state reported")
+	}
+
+	return su, out.String()
+}
+
+func TestExecRunnerLimits(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		limits *ResourceLimits
+		script string
+		state  State
+		breach string
+		err    string
+	}{
+		{
+			name:   "within limits",
+			limits: &ResourceLimits{CPU: time.Second, NoFile: 64},
+			script: "echo ok\n",
+			state:  PassedState,
+		},
+		{
+			name:   "job process cpu time",
+			limits: &ResourceLimits{CPU: time.Second},
+			script: "while :; do :; done\n",
+			state:  ErroredState,
+			breach: "cpu time limit exceeded",
+		},
+		{
+			name:   "child process cpu time",
+			limits: &ResourceLimits{CPU: time.Second},
+			script: "bash -c 'while :; do :; done'\necho \"child exited $?\"\n",
+			state:  ErroredState,
+			breach: "cpu time limit exceeded",
+		},
+		{
+			name:   "child process open files",
+			limits: &ResourceLimits{NoFile: 32},
+			script: "bash -c 'for fd in $(seq 3 31); do eval \"exec $fd</dev/null\"; done; while (( SECONDS < 2 )); do :; done'\necho \"child exited $?\"\n",
+			state:  ErroredState,
+			breach: "open file limit reached",
+		},
+		{
+			name:   "cgroup unavailable",
+			limits: &ResourceLimits{CgroupParent: "/nonexistent/travis-job", PidsMax: 10},
+			script: "echo ran\n",
+			state:  ErroredState,
+			err:    "cgroup",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.limits.NoFile > 0 && checkProcessSampling() != nil {
+				t.Skip(checkProcessSampling())
+			}
+
+			su, out := runTestJob(t, &RunnerConfig{Limits: tc.limits}, tc.script)
+			if su.New() != tc.state {
+				t.Fatalf("expected %s, got %s with %v:\n%s", tc.state, su.New(), su.Meta(), out)
+			}
+
+			if tc.breach != "" && su.Meta()["limit_breach"] != tc.breach {
+				t.Fatalf("expected breach %q, got %v:\n%s", tc.breach, su.Meta(), out)
+			}
+
+			if tc.err != "" {
+				err, _ := su.Meta()["error"].(string)
+				if !strings.Contains(err, tc.err) {
+					t.Fatalf("expected error containing %q, got %v", tc.err, su.Meta())
+				}
+				if strings.Contains(out, "ran") {
+					t.Fatalf("expected the job not to run:\n%s", out)
+				}
+			}
+		})
+	}
+}
+
+func TestExecRunnerStartFailure(t *testing.T) {
+	interps := NewInterpreterRegistry()
+	interps.Register(&Interpreter{Name: "bash", Command: []string{"/nonexistent/bash"}, Extensions: []string{"bash"}})
+
+	su, out := runTestJob(t, &RunnerConfig{Interpreters: interps}, "echo ran\n")
+	if su.New() != ErroredState {
+		t.Fatalf("expected %s, got %s with %v:\n%s", ErroredState, su.New(), su.Meta(), out)
+	}
+
+	err, _ := su.Meta()["error"].(string)
+	if !strings.Contains(err, "/nonexistent/bash") {
+		t.Fatalf("expected the start error in the meta, got %v", su.Meta())
+	}
+
+	if !strings.Contains(out, "Failed to start the job script") {
+		t.Fatalf("expected the start error in the output:\n%s", out)
+	}
+}
@@ -0,0 +1,125 @@
+package job
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// Job script encodings may be layered with "+" and are decoded from right to
+// left, so that "gzip+base64" content is base64-decoded and then gunzipped.
+const (
+	scriptEncodingPlain  = "plain"
+	scriptEncodingBase64 = "base64"
+	scriptEncodingGzip   = "gzip"
+	scriptEncodingZstd   = "zstd"
+)
+
+var (
+	scriptHTTPClient = &http.Client{Timeout: 5 * time.Minute}
+)
+
+func decodeScript(content []byte, encoding string) ([]byte, error) {
+	layers := strings.Split(encoding, "+")
+	for i := len(layers) - 1; i >= 0; i-- {
+		var err error
+
+		switch layers[i] {
+		case scriptEncodingPlain:
+			continue
+		case scriptEncodingBase64:
+			content, err = base64.StdEncoding.DecodeString(string(content))
+		case scriptEncodingGzip:
+			content, err = gunzipScript(content)
+		case scriptEncodingZstd:
+			content, err = unzstdScript(content)
+		default:
+			return nil, fmt.Errorf("unknown job script encoding: %s", encoding)
+		}
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode %s job script", layers[i])
+		}
+	}
+
+	return content, nil
+}
+
+func gunzipScript(content []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func unzstdScript(content []byte) ([]byte, error) {
+	r, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+	return r.DecodeAll(content, nil)
+}
+
+func fetchScript(ctx context.Context, scriptURL string) ([]byte, error) {
+	u, err := url.Parse(scriptURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't parse job script URL")
+	}
+
+	switch u.Scheme {
+	case "file":
+		src, err := filepath.Abs(u.Host + u.Path)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to find absolute job script path")
+		}
+		return ioutil.ReadFile(src)
+	case "http", "https":
+		req, err := http.NewRequest("GET", u.String(), nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't create job script request")
+		}
+
+		resp, err := scriptHTTPClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, errors.Wrap(err, "error making job script request")
+		}
+
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, errors.Errorf("expected %d, but got %d", http.StatusOK, resp.StatusCode)
+		}
+
+		return ioutil.ReadAll(resp.Body)
+	default:
+		return nil, fmt.Errorf("unknown scheme %v", u.Scheme)
+	}
+}
+
+func verifyScriptSHA256(content []byte, expected string) error {
+	sum := sha256.Sum256(content)
+	actual := hex.EncodeToString(sum[:])
+	if !strings.EqualFold(actual, expected) {
+		return errors.Errorf("job script sha256 mismatch: expected %s, but got %s", expected, actual)
+	}
+
+	return nil
+}
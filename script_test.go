@@ -0,0 +1,133 @@
+package job
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestDecodeScript(t *testing.T) {
+	script := "#!/bin/bash\necho hello\n"
+	b64 := base64.StdEncoding.EncodeToString
+
+	gzipped := &bytes.Buffer{}
+	gzw := gzip.NewWriter(gzipped)
+	_, _ = gzw.Write([]byte(script))
+	_ = gzw.Close()
+
+	zw, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zstded := zw.EncodeAll([]byte(script), nil)
+	_ = zw.Close()
+
+	for _, tc := range []struct {
+		name     string
+		content  string
+		encoding string
+		err      string
+	}{
+		{"plain", script, "plain", ""},
+		{"base64", b64([]byte(script)), "base64", ""},
+		{"gzip+base64", b64(gzipped.Bytes()), "gzip+base64", ""},
+		{"zstd+base64", b64(zstded), "zstd+base64", ""},
+		{"bad base64", "not base64!", "base64", "failed to decode base64 job script"},
+		{"gzip of plain text", b64([]byte(script)), "gzip+base64", "failed to decode gzip job script"},
+		{"zstd of plain text", b64([]byte(script)), "zstd+base64", "failed to decode zstd job script"},
+		{"unknown encoding", script, "rot13", "unknown job script encoding"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			decoded, err := decodeScript([]byte(tc.content), tc.encoding)
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if string(decoded) != script {
+					t.Fatalf("expected %q, got %q", script, decoded)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestVerifyScriptSHA256(t *testing.T) {
+	script := []byte("echo hello\n")
+	sum := sha256.Sum256(script)
+	hexSum := hex.EncodeToString(sum[:])
+
+	for _, tc := range []struct {
+		name     string
+		content  []byte
+		expected string
+		err      bool
+	}{
+		{"match", script, hexSum, false},
+		{"uppercase match", script, strings.ToUpper(hexSum), false},
+		{"tampered script", []byte("echo evil\n"), hexSum, true},
+		{"truncated sum", script, hexSum[:32], true},
+		{"not hex", script, "not a sha256", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := verifyScriptSHA256(tc.content, tc.expected)
+			if !tc.err {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), "sha256 mismatch") {
+				t.Fatalf("expected a sha256 mismatch, got %v", err)
+			}
+		})
+	}
+}
+
+func TestJobWrapperScript(t *testing.T) {
+	script := "echo hello\n"
+	sum := sha256.Sum256([]byte(script))
+	b64 := base64.StdEncoding.EncodeToString([]byte(script))
+
+	for _, tc := range []struct {
+		name string
+		js   *jobJobScript
+		err  string
+	}{
+		{"base64", &jobJobScript{Encoding: "base64", Content: b64}, ""},
+		{"base64 with sha256", &jobJobScript{Encoding: "base64", Content: b64, SHA256: hex.EncodeToString(sum[:])}, ""},
+		{"bad base64", &jobJobScript{Encoding: "base64", Content: "%%%"}, "failed to decode base64 job script"},
+		{"sha256 mismatch", &jobJobScript{Encoding: "base64", Content: b64, SHA256: strings.Repeat("0", 64)}, "sha256 mismatch"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			j := &jobWrapper{J: &job{JobScript: tc.js}}
+
+			actual, err := j.Script(context.Background())
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if actual != script {
+					t.Fatalf("expected %q, got %q", script, actual)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
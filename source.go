@@ -2,14 +2,19 @@ package job
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/cenk/backoff"
@@ -21,6 +26,10 @@ var (
 	remoteSourceNoJobErr = fmt.Errorf("no jobs available")
 )
 
+const (
+	httpSourceMaxPayloadSize = 10 * 1024 * 1024
+)
+
 type Source interface {
 	Fetch(context.Context) (Job, error)
 }
@@ -41,6 +50,18 @@ func NewLocalSource(log logrus.FieldLogger, jobPath, processorID string) Source
 	}
 }
 
+// NewHTTPSource builds a Source that listens on listenAddr and accepts a
+// single job payload POSTed to /jobs with the shared token as bearer
+// authorization.
+func NewHTTPSource(log logrus.FieldLogger, listenAddr, token, processorID string) Source {
+	return &httpSource{
+		log:         log.WithField("self", "http_source"),
+		listenAddr:  listenAddr,
+		token:       token,
+		processorID: processorID,
+	}
+}
+
 type remoteSource struct {
 	log         logrus.FieldLogger
 	jobURL      string
@@ -69,6 +90,10 @@ func (rs *remoteSource) Fetch(ctx context.Context) (Job, error) {
 	req.Header.Add("Content-Type", "application/json")
 	req.Header.Add("Travis-Site", "com")
 	req.Header.Add("From", rs.processorID)
+	if c, ok := capacityFromContext(ctx); ok {
+		req.Header.Add("Travis-Processor-Capacity", strconv.Itoa(c.total))
+		req.Header.Add("Travis-Processor-Available", strconv.Itoa(c.available))
+	}
 	req = req.WithContext(ctx)
 
 	resp, err := client.Do(req)
@@ -174,3 +199,158 @@ func (ls *localSource) Fetch(ctx context.Context) (Job, error) {
 
 	return newJobFromBytes(jobBytes)
 }
+
+type httpSource struct {
+	log         logrus.FieldLogger
+	listenAddr  string
+	token       string
+	processorID string
+}
+
+func (hs *httpSource) Fetch(ctx context.Context) (Job, error) {
+	if hs.token == "" {
+		return nil, fmt.Errorf("refusing to accept jobs without a token")
+	}
+
+	listener, err := net.Listen("tcp", hs.listenAddr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to listen for jobs")
+	}
+
+	jobs := make(chan Job, 1)
+	handler := &httpSourceHandler{
+		log:         hs.log,
+		token:       hs.token,
+		processorID: hs.processorID,
+		jobs:        jobs,
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/jobs", handler)
+	server := &http.Server{Handler: mux}
+
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			hs.log.WithError(err).Error("failed to serve job listener")
+		}
+	}()
+
+	hs.log.WithField("addr", listener.Addr().String()).Info("waiting for job")
+
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		err := server.Shutdown(shutdownCtx)
+		if err != nil {
+			hs.log.WithError(err).Warn("failed to shut down job listener")
+		}
+	}()
+
+	select {
+	case j := <-jobs:
+		return j, nil
+	case <-ctx.Done():
+	}
+
+	handler.close()
+
+	select {
+	case j := <-jobs:
+		// The job was accepted just as the context was done, and its sender
+		// was told so, so it must still be run and reported on.
+		return j, nil
+	default:
+		return nil, ctx.Err()
+	}
+}
+
+type httpSourceHandler struct {
+	log         logrus.FieldLogger
+	token       string
+	processorID string
+	jobs        chan<- Job
+
+	mu       sync.Mutex
+	accepted bool
+	closed   bool
+}
+
+func (hsh *httpSourceHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	log := hsh.log.WithFields(logrus.Fields{
+		"remote_addr": req.RemoteAddr,
+	})
+
+	w.Header().Set("From", hsh.processorID)
+
+	if req.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !hsh.authorized(req) {
+		log.Warn("rejecting unauthorized job request")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, httpSourceMaxPayloadSize))
+	if err != nil {
+		log.WithError(err).Error("failed to read job payload")
+		http.Error(w, "failed to read job payload", http.StatusBadRequest)
+		return
+	}
+
+	j, err := newJobFromBytes(body)
+	if err == nil {
+		err = validateJob(req.Context(), j)
+	}
+
+	if err != nil {
+		log.WithError(err).Warn("rejecting invalid job payload")
+		http.Error(w, fmt.Sprintf("invalid job payload: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	hsh.mu.Lock()
+	defer hsh.mu.Unlock()
+
+	if hsh.closed {
+		http.Error(w, "no longer accepting jobs", http.StatusServiceUnavailable)
+		return
+	}
+
+	if hsh.accepted {
+		http.Error(w, "a job has already been accepted", http.StatusConflict)
+		return
+	}
+
+	hsh.accepted = true
+	hsh.jobs <- j
+
+	log.WithField("job_id", j.ID()).Info("accepted job")
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// close stops the handler from accepting a job, so that later requests are
+// answered with 503.
+func (hsh *httpSourceHandler) close() {
+	hsh.mu.Lock()
+	defer hsh.mu.Unlock()
+
+	hsh.closed = true
+}
+
+func (hsh *httpSourceHandler) authorized(req *http.Request) bool {
+	auth := req.Header.Get("Authorization")
+	for _, prefix := range []string{"Bearer ", "token "} {
+		if strings.HasPrefix(auth, prefix) {
+			token := strings.TrimPrefix(auth, prefix)
+			return subtle.ConstantTimeCompare([]byte(token), []byte(hsh.token)) == 1
+		}
+	}
+
+	return false
+}
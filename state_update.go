@@ -3,6 +3,7 @@ package job
 type StateUpdate interface {
 	Cur() State
 	New() State
+	Meta() map[string]interface{}
 }
 
 type State string
@@ -18,6 +19,7 @@ const (
 	ReceivedState  = "received"
 	RestartedState = "restarted"
 	StartedState   = "started"
+	WarmedState    = "warmed"
 )
 
 type serializableStateUpdate struct {
@@ -25,7 +27,7 @@ type serializableStateUpdate struct {
 	CurrentState State                  `json:"cur"`
 	NewState     State                  `json:"new"`
 	State        State                  `json:"state"`
-	Meta         map[string]interface{} `json:"meta"`
+	MetaData     map[string]interface{} `json:"meta"`
 }
 
 func (ssu *serializableStateUpdate) Cur() State {
@@ -36,12 +38,24 @@ func (ssu *serializableStateUpdate) New() State {
 	return ssu.NewState
 }
 
+func (ssu *serializableStateUpdate) Meta() map[string]interface{} {
+	return ssu.MetaData
+}
+
 func NewStateUpdate(jobID string, curState, newState State) StateUpdate {
+	return NewStateUpdateWithMeta(jobID, curState, newState, nil)
+}
+
+func NewStateUpdateWithMeta(jobID string, curState, newState State, meta map[string]interface{}) StateUpdate {
+	if meta == nil {
+		meta = map[string]interface{}{}
+	}
+
 	return &serializableStateUpdate{
 		ID:           jobID,
 		CurrentState: curState,
 		NewState:     newState,
 		State:        newState,
-		Meta:         map[string]interface{}{},
+		MetaData:     meta,
 	}
 }
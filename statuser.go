@@ -11,7 +11,6 @@ import (
 	"os"
 	"path/filepath"
 
-	"github.com/jtacoma/uritemplates"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
@@ -31,21 +30,9 @@ type urlStatuser struct {
 }
 
 func (us *urlStatuser) Status(ctx context.Context, job Job, stateUpdate StateUpdate) error {
-	template, err := uritemplates.Parse(job.JobStateURL())
+	u, err := expandJobURL(job.JobStateURL(), job, nil)
 	if err != nil {
-		return errors.Wrap(err, "couldn't parse base URL template")
-	}
-
-	expanded, err := template.Expand(map[string]interface{}{
-		"job_id": job.ID(),
-	})
-	if err != nil {
-		return errors.Wrap(err, "couldn't expand base URL template")
-	}
-
-	u, err := url.Parse(expanded)
-	if err != nil {
-		return errors.Wrap(err, "couldn't parse expanded URL")
+		return err
 	}
 
 	switch u.Scheme {
@@ -1,6 +1,11 @@
 package job
 
-import "io"
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+)
 
 const (
 	stdOutErrName = "stdouterr"
@@ -41,9 +46,48 @@ func (s *ioStream) SetDest(w io.Writer) {
 }
 
 func NewStdOutErrStream() Stream {
-	return NewNamedStream(stdOutErrName)
+	return &ioStream{name: stdOutErrName, dest: os.Stdout}
 }
 
 func NewNamedStream(name string) Stream {
 	return &ioStream{name: name}
 }
+
+// linePrefixWriter writes everything written through it to w with prefix at
+// the start of every line.
+type linePrefixWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	prefix  []byte
+	midLine bool
+}
+
+func newLinePrefixWriter(w io.Writer, prefix string) *linePrefixWriter {
+	return &linePrefixWriter{w: w, prefix: []byte(prefix)}
+}
+
+func (lpw *linePrefixWriter) Write(p []byte) (int, error) {
+	lpw.mu.Lock()
+	defer lpw.mu.Unlock()
+
+	buf := make([]byte, 0, len(p)+len(lpw.prefix))
+	for _, line := range bytes.SplitAfter(p, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+
+		if !lpw.midLine {
+			buf = append(buf, lpw.prefix...)
+		}
+
+		buf = append(buf, line...)
+		lpw.midLine = line[len(line)-1] != '\n'
+	}
+
+	_, err := lpw.w.Write(buf)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
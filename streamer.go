@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"os"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -35,8 +34,8 @@ func (hs *urlStreamer) Stream(ctx context.Context, job Job, str Stream) error {
 
 	for {
 		// { TODO: do http stuff
-		log.Debug("copying to stdout")
-		_, _ = io.Copy(os.Stdout, str.Source())
+		log.WithField("stream", str.Name()).Debug("copying to dest")
+		_, _ = io.Copy(str.Dest(), str.Source())
 		// }
 		select {
 		case <-ctx.Done():
@@ -0,0 +1,58 @@
+package job
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	traceStreamName = "trace"
+
+	// traceFD is the descriptor the job's shell writes its trace to, which
+	// is the first of exec.Cmd.ExtraFiles.
+	traceFD = 3
+)
+
+// supportsTrace reports whether the interpreter is bash, which can be told to
+// write its xtrace output to a dedicated descriptor.
+func (interp *Interpreter) supportsTrace() bool {
+	return len(interp.Command) > 0 && path.Base(interp.Command[0]) == "bash"
+}
+
+// tracedCommandFor is commandFor with bash's -x option, which goes right
+// before the script path because bash rejects long options, such as those of
+// a custom bash interpreter, that follow short ones.
+func (interp *Interpreter) tracedCommandFor(scriptPath string) (string, []string) {
+	return interp.Command[0], append(append(append([]string{}, interp.Command[1:]...), "-x"), scriptPath)
+}
+
+// copyTimestampedLines copies src to dst line by line, prefixing each line
+// with the time at which it was read.
+func copyTimestampedLines(dst io.Writer, src io.Reader) error {
+	r := bufio.NewReader(src)
+	for {
+		line, err := r.ReadString('\n')
+		if line != "" {
+			if !strings.HasSuffix(line, "\n") {
+				line += "\n"
+			}
+
+			_, writeErr := fmt.Fprintf(dst, "%s %s", time.Now().UTC().Format(time.RFC3339Nano), line)
+			if writeErr != nil {
+				return writeErr
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
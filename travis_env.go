@@ -0,0 +1,94 @@
+package job
+
+import (
+	"fmt"
+	"os"
+)
+
+const (
+	defaultCleanEnvPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+)
+
+var (
+	// cleanEnvInherited are the only variables passed on from travis-job's
+	// own environment to job processes when running with a clean env.
+	cleanEnvInherited = []string{"PATH", "USER", "LOGNAME", "SHELL", "LANG", "TZ"}
+)
+
+// baseEnviron is the environment job processes start from, before any of the
+// job's own variables are added.
+func (er *execRunner) baseEnviron() []string {
+	if !er.cfg.CleanEnv {
+		return os.Environ()
+	}
+
+	environ := []string{}
+	for _, name := range cleanEnvInherited {
+		value, ok := os.LookupEnv(name)
+		if !ok && name == "PATH" {
+			value, ok = defaultCleanEnvPath, true
+		}
+
+		if ok {
+			environ = append(environ, fmt.Sprintf("%s=%s", name, value))
+		}
+	}
+
+	return environ
+}
+
+// travisEnviron returns the standard TRAVIS_* variables describing the job,
+// as derived from its payload.
+func travisEnviron(job Job) []string {
+	md := job.Metadata()
+
+	pullRequest := "false"
+	if md.Job.IsPullRequest() {
+		pullRequest = fmt.Sprintf("%d", md.Job.PullRequest)
+	}
+
+	branch := md.Job.Branch
+	if md.Job.Tag != "" && branch == "" {
+		branch = md.Job.Tag
+	}
+
+	vars := [][2]string{
+		{"CI", "true"},
+		{"CONTINUOUS_INTEGRATION", "true"},
+		{"TRAVIS", "true"},
+		{"TRAVIS_JOB_ID", job.ID()},
+		{"TRAVIS_JOB_NUMBER", md.Job.Number},
+		{"TRAVIS_BUILD_ID", fmt.Sprintf("%d", md.Build.ID)},
+		{"TRAVIS_BUILD_NUMBER", md.Build.Number},
+		{"TRAVIS_BUILD_STAGE_NAME", md.Job.StageName},
+		{"TRAVIS_REPO_SLUG", md.Repository.Slug},
+		{"TRAVIS_COMMIT", md.Job.Commit},
+		{"TRAVIS_COMMIT_RANGE", md.Job.CommitRange},
+		{"TRAVIS_COMMIT_MESSAGE", md.Job.CommitMessage},
+		{"TRAVIS_BRANCH", branch},
+		{"TRAVIS_TAG", md.Job.Tag},
+		{"TRAVIS_PULL_REQUEST", pullRequest},
+		{"TRAVIS_EVENT_TYPE", md.Build.EventType},
+		{"TRAVIS_SECURE_ENV_VARS", fmt.Sprintf("%v", md.Job.SecureEnvEnabled)},
+		{"TRAVIS_ALLOW_FAILURE", fmt.Sprintf("%v", md.Job.AllowFailure)},
+		{"TRAVIS_QUEUE", md.Queue},
+		{"TRAVIS_OS_NAME", configString(md.Config, "os")},
+		{"TRAVIS_DIST", configString(md.Config, "dist")},
+		{"TRAVIS_LANGUAGE", configString(md.Config, "language")},
+	}
+
+	environ := []string{}
+	for _, v := range vars {
+		environ = append(environ, fmt.Sprintf("%s=%s", v[0], v[1]))
+	}
+
+	return environ
+}
+
+func configString(config map[string]interface{}, key string) string {
+	if s, ok := config[key].(string); ok {
+		return s
+	}
+
+	return ""
+}
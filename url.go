@@ -0,0 +1,36 @@
+package job
+
+import (
+	"net/url"
+
+	"github.com/jtacoma/uritemplates"
+	"github.com/pkg/errors"
+)
+
+// expandJobURL expands one of a job's URL templates, which may refer to
+// {job_id} and to any of the extra vars given.
+func expandJobURL(tmpl string, job Job, vars map[string]interface{}) (*url.URL, error) {
+	template, err := uritemplates.Parse(tmpl)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't parse base URL template")
+	}
+
+	values := map[string]interface{}{
+		"job_id": job.ID(),
+	}
+	for k, v := range vars {
+		values[k] = v
+	}
+
+	expanded, err := template.Expand(values)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't expand base URL template")
+	}
+
+	u, err := url.Parse(expanded)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't parse expanded URL")
+	}
+
+	return u, nil
+}
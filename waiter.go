@@ -2,20 +2,77 @@ package job
 
 import (
 	"context"
+	"sync"
 	"time"
 
+	"github.com/cenk/backoff"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
-func NewWaiter(log logrus.FieldLogger, interval, max time.Duration,
+const (
+	defaultMaxWaitInterval = time.Minute
+)
+
+// WaiterConfig holds the settings for fetching jobs.  Failed fetches are
+// retried with exponential backoff from Interval up to MaxInterval, with each
+// interval randomized by up to half either way.  Waiting for a job gives up
+// after MaxWait, or after MaxFetchErrors consecutive fetch failures other than
+// there being no job available, unless MaxFetchErrors is 0.
+type WaiterConfig struct {
+	Interval       time.Duration
+	MaxInterval    time.Duration
+	MaxWait        time.Duration
+	MaxFetchErrors int
+}
+
+func NewWaiter(log logrus.FieldLogger, cfg *WaiterConfig,
 	src Source, runner Runner) Waiter {
 
+	return newFetchRetryWaiter(log.WithField("self", "fetch_retry_waiter"), cfg, src, runner)
+}
+
+func newFetchRetryWaiter(log logrus.FieldLogger, cfg *WaiterConfig,
+	src Source, runner Runner) *fetchRetryWaiter {
+
+	if cfg.Interval == 0 {
+		cfg.Interval = backoff.DefaultInitialInterval
+	}
+
+	if cfg.MaxInterval == 0 {
+		cfg.MaxInterval = defaultMaxWaitInterval
+	}
+
+	if cfg.MaxInterval < cfg.Interval {
+		cfg.MaxInterval = cfg.Interval
+	}
+
 	return &fetchRetryWaiter{
-		log:      log,
-		interval: interval,
-		max:      max,
-		src:      src,
-		runner:   runner,
+		log:    log,
+		cfg:    cfg,
+		src:    src,
+		runner: runner,
+	}
+}
+
+// NewPoolWaiter builds a Waiter that keeps fetching and running up to size
+// jobs at once until its context is done, maxJobs jobs have run, or drain is
+// closed.  A zero maxJobs means no limit.  Jobs are fetched from src one at a
+// time whenever a slot is free, advertising the pool's capacity to the
+// source.  Waiting for any single job is limited to MaxWait, and every job
+// runs with its own context, which carries its slot when size is above 1.
+func NewPoolWaiter(log logrus.FieldLogger, cfg *WaiterConfig,
+	size, maxJobs int, drain <-chan struct{}, src Source, runner Runner) Waiter {
+
+	if size < 1 {
+		size = 1
+	}
+
+	return &poolWaiter{
+		fetchRetryWaiter: newFetchRetryWaiter(log.WithField("self", "pool_waiter"), cfg, src, runner),
+		size:             size,
+		maxJobs:          maxJobs,
+		drain:            drain,
 	}
 }
 
@@ -24,33 +81,202 @@ type Waiter interface {
 }
 
 type fetchRetryWaiter struct {
-	log           logrus.FieldLogger
-	interval, max time.Duration
-	src           Source
-	runner        Runner
+	log    logrus.FieldLogger
+	cfg    *WaiterConfig
+	src    Source
+	runner Runner
 }
 
+// Wait fetches a job within MaxWait and runs it.  MaxWait only limits the
+// fetch, not how long the job may run.
 func (w *fetchRetryWaiter) Wait(ctx context.Context) error {
-	ctx, cancel := context.WithTimeout(ctx, w.max)
+	fetchCtx, cancel := context.WithTimeout(ctx, w.cfg.MaxWait)
 	defer cancel()
 
+	j, err := w.fetch(fetchCtx)
+	if err != nil {
+		return err
+	}
+
+	return w.runner.Run(ctx, j)
+}
+
+// fetch retries fetching a job with backoff until it gets one, the context
+// is done, or there have been too many consecutive fetch errors.  The backoff
+// starts over with every call.
+func (w *fetchRetryWaiter) fetch(ctx context.Context) (Job, error) {
+	bo := backoff.NewExponentialBackOff()
+	bo.InitialInterval = w.cfg.Interval
+	bo.MaxInterval = w.cfg.MaxInterval
+	bo.MaxElapsedTime = 0
+	bo.Reset()
+
+	fetchErrors := 0
 	for {
 		j, err := w.src.Fetch(ctx)
+		if err == nil {
+			return j, nil
+		}
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		interval := bo.NextBackOff()
+		log := w.log.WithField("interval", interval)
+
+		if errors.Cause(err) == remoteSourceNoJobErr {
+			fetchErrors = 0
+			log.Debug("no jobs available, waiting")
+		} else {
+			fetchErrors++
+			log.WithError(err).WithField("errors", fetchErrors).Warn("failed to fetch job")
+
+			if w.cfg.MaxFetchErrors > 0 && fetchErrors >= w.cfg.MaxFetchErrors {
+				return nil, errors.Wrapf(err, "giving up after %d consecutive fetch errors", fetchErrors)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+type poolWaiter struct {
+	*fetchRetryWaiter
+
+	size    int
+	maxJobs int
+	drain   <-chan struct{}
+}
+
+func (w *poolWaiter) Wait(ctx context.Context) error {
+	slots := make(chan int, w.size)
+	for slot := 1; slot <= w.size; slot++ {
+		slots <- slot
+	}
+
+	running := &sync.WaitGroup{}
+	defer running.Wait()
+
+	for n := 0; w.maxJobs == 0 || n < w.maxJobs; n++ {
+		var slot int
+		select {
+		case slot = <-slots:
+		case <-w.drain:
+		case <-ctx.Done():
+		}
+
+		if w.draining() || ctx.Err() != nil {
+			w.log.WithField("jobs", n).Info("stopping")
+			return nil
+		}
+
+		log := w.log.WithField("slot", slot)
+
+		fetchCtx := contextWithCapacity(ctx, w.size, len(slots)+1)
+		j, err := w.fetchUntilDrained(fetchCtx)
 		if err != nil {
-			w.log.WithFields(logrus.Fields{
-				"err":      err,
-				"interval": w.interval,
-			}).Debug("waiting for job")
-
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			default:
-				time.Sleep(w.interval)
-				continue
+			slots <- slot
+			if w.draining() || ctx.Err() != nil {
+				log.WithField("jobs", n).Info("stopping")
+				return nil
 			}
+
+			return err
+		}
+
+		running.Add(1)
+		go func() {
+			defer func() {
+				slots <- slot
+				running.Done()
+			}()
+
+			w.run(ctx, log.WithField("job_id", j.ID()), slot, j)
+		}()
+	}
+
+	w.log.WithField("jobs", w.maxJobs).Info("reached max jobs, waiting for running jobs")
+	return nil
+}
+
+func (w *poolWaiter) run(ctx context.Context, log logrus.FieldLogger, slot int, j Job) {
+	log.Info("running job")
+
+	// with a single slot there are no other jobs to tell this one's output
+	// apart from
+	if w.size > 1 {
+		ctx = contextWithSlot(ctx, slot)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	err := w.runner.Run(ctx, j)
+	if err != nil {
+		log.WithError(err).Error("job run failed")
+		return
+	}
+
+	log.Info("job run completed")
+}
+
+// fetchUntilDrained fetches a job like fetch, but gives up once the waiter is
+// drained or no job has turned up within MaxWait.
+func (w *poolWaiter) fetchUntilDrained(ctx context.Context) (Job, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.cfg.MaxWait)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-w.drain:
+			cancel()
+		case <-ctx.Done():
 		}
+	}()
+
+	return w.fetch(ctx)
+}
 
-		return w.runner.Run(ctx, j)
+func (w *poolWaiter) draining() bool {
+	select {
+	case <-w.drain:
+		return true
+	default:
+		return false
 	}
 }
+
+type capacityContextKey struct{}
+
+// capacity is how many jobs a processor can run at once, and how many more
+// it can take on right now.
+type capacity struct {
+	total, available int
+}
+
+func contextWithCapacity(ctx context.Context, total, available int) context.Context {
+	return context.WithValue(ctx, capacityContextKey{}, capacity{total: total, available: available})
+}
+
+func capacityFromContext(ctx context.Context) (capacity, bool) {
+	c, ok := ctx.Value(capacityContextKey{}).(capacity)
+	return c, ok
+}
+
+type slotContextKey struct{}
+
+// contextWithSlot marks a job's context with the pool slot it runs in, so
+// that its log lines and shared output can be told apart from other jobs'.
+func contextWithSlot(ctx context.Context, slot int) context.Context {
+	return context.WithValue(ctx, slotContextKey{}, slot)
+}
+
+func slotFromContext(ctx context.Context) (int, bool) {
+	slot, ok := ctx.Value(slotContextKey{}).(int)
+	return slot, ok
+}
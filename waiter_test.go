@@ -0,0 +1,104 @@
+package job
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type testSource struct {
+	jobs chan Job
+}
+
+func (ts *testSource) Fetch(ctx context.Context) (Job, error) {
+	select {
+	case j := <-ts.jobs:
+		return j, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type testRunner func(context.Context, Job) error
+
+func (tr testRunner) Run(ctx context.Context, j Job) error {
+	return tr(ctx, j)
+}
+
+func TestFetchRetryWaiterMaxWait(t *testing.T) {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+
+	cfg := &WaiterConfig{MaxWait: 50 * time.Millisecond}
+
+	t.Run("limits the fetch", func(t *testing.T) {
+		src := &testSource{jobs: make(chan Job)}
+		runner := testRunner(func(context.Context, Job) error {
+			t.Fatal("unexpected run")
+			return nil
+		})
+
+		err := NewWaiter(log, cfg, src, runner).Wait(context.Background())
+		if err != context.DeadlineExceeded {
+			t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+		}
+	})
+
+	t.Run("does not limit the job", func(t *testing.T) {
+		src := &testSource{jobs: make(chan Job, 1)}
+		src.jobs <- &jobWrapper{J: &job{Data: &jobData{Job: &jobDataJob{ID: 42}}}}
+
+		runner := testRunner(func(ctx context.Context, j Job) error {
+			select {
+			case <-ctx.Done():
+				t.Fatalf("job context done after %v: %v", cfg.MaxWait, ctx.Err())
+			case <-time.After(4 * cfg.MaxWait):
+			}
+			return nil
+		})
+
+		err := NewWaiter(log, cfg, src, runner).Wait(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestPoolWaiterSlots(t *testing.T) {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+
+	for _, tc := range []struct {
+		name string
+		size int
+		slot bool
+	}{
+		{"single slot", 1, false},
+		{"several slots", 2, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			src := &testSource{jobs: make(chan Job, 1)}
+			src.jobs <- &jobWrapper{J: &job{Data: &jobData{Job: &jobDataJob{ID: 42}}}}
+
+			slotted := make(chan bool, 1)
+			runner := testRunner(func(ctx context.Context, j Job) error {
+				_, ok := slotFromContext(ctx)
+				slotted <- ok
+				return nil
+			})
+
+			cfg := &WaiterConfig{MaxWait: time.Second}
+			err := NewPoolWaiter(log, cfg, tc.size, 1, nil, src, runner).Wait(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if ok := <-slotted; ok != tc.slot {
+				t.Fatalf("expected slot in job context %v, got %v", tc.slot, ok)
+			}
+		})
+	}
+}
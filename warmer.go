@@ -0,0 +1,78 @@
+package job
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// warm handles warmer jobs, which prepare the processor for the jobs that
+// follow instead of running a job script.  The job's build cache archive is
+// fetched into the warmed cache under the workspace root, from which the next
+// job of the same repository and branch restores its cache, and the
+// configured warm-up command is run in a scratch workspace with the image and
+// queue named by the payload in its environment.
+func (er *execRunner) warm(ctx context.Context, log logrus.FieldLogger, job Job) error {
+	log = log.WithField("warmer", true)
+
+	ws, err := newWorkspace(er.cfg.WorkspaceRoot, job.ID())
+	if err != nil {
+		log.WithError(err).Error("failed to create workspace")
+		er.status(ctx, job, ReceivedState, ErroredState)
+		return errors.Wrap(err, "failed to create workspace")
+	}
+
+	defer er.cleanupWorkspace(log, ws)
+
+	stdOutErr, ok := job.Streams()[stdOutErrName]
+	if !ok {
+		log.Error("job is missing stdouterr stream")
+		er.status(ctx, job, ReceivedState, ErroredState)
+		return fmt.Errorf("missing stdouterr stream")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pw := er.startStream(ctx, log, job, stdOutErr)
+	defer pw.Close()
+
+	jc, err := newJobCache(job, ws, er.cfg.CacheURL)
+	if err != nil {
+		log.WithError(err).Warn("warming without build cache")
+	}
+
+	if jc != nil {
+		jc.warm(ctx, log, pw)
+	}
+
+	if er.cfg.WarmupCommand == "" {
+		log.Debug("no warm-up command configured")
+		er.status(ctx, job, ReceivedState, WarmedState)
+		return nil
+	}
+
+	log.Info("running warm-up command")
+	err = er.runShellCommand(ctx, log, er.cfg.WarmupCommand, ws.buildDir, er.warmupEnviron(job, ws), pw)
+	if err != nil {
+		log.WithError(err).Error("warm-up command failed")
+		er.status(ctx, job, ReceivedState, ErroredState)
+		return errors.Wrap(err, "warm-up command failed")
+	}
+
+	er.status(ctx, job, ReceivedState, WarmedState)
+	log.Debug("warmed")
+	return nil
+}
+
+// warmupEnviron is the environment the warm-up command is run with.
+func (er *execRunner) warmupEnviron(job Job, ws *workspace) []string {
+	md := job.Metadata()
+	return append(append(er.baseEnviron(), ws.environ()...),
+		fmt.Sprintf("TRAVIS_JOB_ID=%s", job.ID()),
+		fmt.Sprintf("TRAVIS_JOB_IMAGE_NAME=%s", md.ImageName),
+		fmt.Sprintf("TRAVIS_JOB_QUEUE=%s", md.Queue),
+		fmt.Sprintf("TRAVIS_JOB_VM_TYPE=%s", md.VMType))
+}
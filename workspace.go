@@ -0,0 +1,163 @@
+package job
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	workspaceBuildDirName = "build"
+	warmCacheDirName      = "travis-job-warm-cache"
+)
+
+// workspace is a private directory created for a single job, holding the job
+// script and the build directory the script runs in.  It also serves as the
+// job's HOME.
+type workspace struct {
+	dir      string
+	buildDir string
+	jobID    string
+}
+
+func newWorkspace(root, jobID string) (*workspace, error) {
+	if root == "" {
+		root = os.TempDir()
+	}
+
+	// Job commands run in the build dir, so paths into the workspace must
+	// not be relative to the current one.
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find absolute workspace root")
+	}
+
+	err = os.MkdirAll(root, os.FileMode(0755))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create workspace root")
+	}
+
+	dir, err := ioutil.TempDir(root, fmt.Sprintf("travis-job-%s-", jobID))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create workspace")
+	}
+
+	err = os.Chmod(dir, os.FileMode(0700))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to restrict workspace permissions")
+	}
+
+	ws := &workspace{
+		dir:      dir,
+		buildDir: filepath.Join(dir, workspaceBuildDirName),
+		jobID:    jobID,
+	}
+
+	err = os.Mkdir(ws.buildDir, os.FileMode(0700))
+	if err != nil {
+		_ = ws.remove()
+		return nil, errors.Wrap(err, "failed to create build dir")
+	}
+
+	return ws, nil
+}
+
+func (ws *workspace) scriptPath(ext string) string {
+	return filepath.Join(ws.dir, fmt.Sprintf("travis-job-%s.%s", ws.jobID, ext))
+}
+
+// warmCacheDir is where warmer jobs leave cache archives for the jobs that
+// follow them, next to the workspaces under the same root.  Unlike a
+// workspace its path is predictable, so it must pass checkPrivateDir before
+// anything in it is used.
+func (ws *workspace) warmCacheDir() string {
+	return filepath.Join(filepath.Dir(ws.dir), warmCacheDirName)
+}
+
+// privateDir creates dir if it does not exist yet and then checks it with
+// checkPrivateDir.
+func privateDir(dir string) error {
+	err := os.Mkdir(dir, os.FileMode(0700))
+	if err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	return checkPrivateDir(dir)
+}
+
+// checkPrivateDir checks that dir is a directory rather than a symlink, owned
+// by the current user and with mode 0700, so that nothing in it can have been
+// put there by another user.
+func checkPrivateDir(dir string) error {
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	if fi.Mode().Perm() != os.FileMode(0700) {
+		return fmt.Errorf("%s has mode %#o rather than 0700", dir, fi.Mode().Perm())
+	}
+
+	if !ownedByCurrentUser(fi) {
+		return fmt.Errorf("%s is not owned by the current user", dir)
+	}
+
+	return nil
+}
+
+func (ws *workspace) environ() []string {
+	return []string{
+		fmt.Sprintf("HOME=%s", ws.dir),
+		fmt.Sprintf("TRAVIS_BUILD_DIR=%s", ws.buildDir),
+	}
+}
+
+// resolve turns a path given by the job config into an absolute path, with a
+// leading "~" or "$HOME" meaning the workspace and relative paths being
+// relative to the build dir.  Paths outside of the workspace are refused.
+func (ws *workspace) resolve(path string) (string, error) {
+	resolved := path
+	for _, home := range []string{"~", "$HOME", "${HOME}"} {
+		if resolved == home || strings.HasPrefix(resolved, home+"/") {
+			resolved = filepath.Join(ws.dir, strings.TrimPrefix(resolved, home))
+			break
+		}
+	}
+
+	if !filepath.IsAbs(resolved) {
+		resolved = filepath.Join(ws.buildDir, resolved)
+	}
+
+	resolved = filepath.Clean(resolved)
+	if !pathWithin(ws.dir, resolved) {
+		return "", fmt.Errorf("path %q is outside of the workspace", path)
+	}
+
+	return resolved, nil
+}
+
+// remove deletes the whole workspace tree, making directories writable first
+// if the job left any read-only ones behind.
+func (ws *workspace) remove() error {
+	err := os.RemoveAll(ws.dir)
+	if err == nil {
+		return nil
+	}
+
+	_ = filepath.Walk(ws.dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() {
+			_ = os.Chmod(path, info.Mode()|os.FileMode(0700))
+		}
+		return nil
+	})
+
+	return os.RemoveAll(ws.dir)
+}
@@ -0,0 +1,103 @@
+package job
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testOtherUID is the uid of nobody, for directories made by another user.
+const testOtherUID = 65534
+
+func TestPrivateDir(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		setup func(t *testing.T, dir string)
+		err   string
+	}{
+		{
+			name:  "created",
+			setup: func(t *testing.T, dir string) {},
+		},
+		{
+			name: "already ours",
+			setup: func(t *testing.T, dir string) {
+				mustMkdir(t, dir, 0700)
+			},
+		},
+		{
+			name: "too open",
+			setup: func(t *testing.T, dir string) {
+				mustMkdir(t, dir, 0777)
+			},
+			err: "rather than 0700",
+		},
+		{
+			name: "symlink to a private dir",
+			setup: func(t *testing.T, dir string) {
+				target := dir + "-target"
+				mustMkdir(t, target, 0700)
+				if err := os.Symlink(target, dir); err != nil {
+					t.Fatal(err)
+				}
+			},
+			err: "is not a directory",
+		},
+		{
+			name: "file",
+			setup: func(t *testing.T, dir string) {
+				if err := ioutil.WriteFile(dir, nil, 0700); err != nil {
+					t.Fatal(err)
+				}
+			},
+			err: "is not a directory",
+		},
+		{
+			name: "pre-created by another user",
+			setup: func(t *testing.T, dir string) {
+				mustMkdirAs(t, dir, testOtherUID)
+			},
+			err: "is not owned by the current user",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), warmCacheDirName)
+			tc.setup(t, dir)
+
+			err := privateDir(dir)
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func mustMkdir(t *testing.T, dir string, mode os.FileMode) {
+	if err := os.Mkdir(dir, mode); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(dir, mode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// mustMkdirAs makes a 0700 dir owned by uid, which needs root.
+func mustMkdirAs(t *testing.T, dir string, uid int) {
+	if os.Getuid() != 0 {
+		t.Skip("changing the owner of a directory needs root")
+	}
+
+	mustMkdir(t, dir, 0700)
+	if err := os.Chown(dir, uid, uid); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package job
+
+import (
+	"os"
+	"syscall"
+)
+
+func ownedByCurrentUser(fi os.FileInfo) bool {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && int(st.Uid) == os.Getuid()
+}